@@ -0,0 +1,123 @@
+package fileMonitor
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Folder within the MonitorFolder that files are moved into while being processed
+const claimFolderName = ".processing"
+
+type RecoveryPolicy int
+
+const (
+	RecoveryPolicyRetry RecoveryPolicy = iota // Moves claimed files back into the MonitorFolder to be processed again
+	RecoveryPolicyError                       // Sends claimed files to the ErrorCopiers and removes them
+	RecoveryPolicyLeave                       // Leaves claimed files in the claim folder for manual intervention
+)
+
+func (d *Dir) claimFolder() string {
+	return filepath.Join(d.monitorFolder(), claimFolderName)
+}
+
+func (d *Dir) isClaimFolder(path string) bool {
+	return filepath.Clean(path) == d.claimFolder()
+}
+
+// Atomically moves the file into the claim folder keeping its path relative to the MonitorFolder
+func (d *Dir) claim(filePath string) (string, error) {
+	relPath, err := filepath.Rel(d.monitorFolder(), filePath)
+	if err != nil {
+		return "", fmt.Errorf("unable to get relative path: %w", err)
+	}
+
+	claimedPath := filepath.Join(d.claimFolder(), relPath)
+	err = os.MkdirAll(filepath.Dir(claimedPath), os.ModePerm)
+	if err != nil {
+		return "", fmt.Errorf("unable to create claim directory: %w", err)
+	}
+
+	err = os.Rename(filePath, claimedPath)
+	if err != nil {
+		return "", fmt.Errorf("unable to claim file: %w", err)
+	}
+	return claimedPath, nil
+}
+
+// Removes empty directories left in the claim folder after a claimed file was removed
+func (d *Dir) cleanClaimFolder(claimedPath string) {
+	claimFolder := d.claimFolder()
+	for dir := filepath.Dir(claimedPath); dir != claimFolder && strings.HasPrefix(dir, claimFolder); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
+
+// Handles files left in the claim folder by a previous run according to the Recovery policy
+func (d *Dir) recoverClaimed() error {
+	claimFolder := d.claimFolder()
+	if _, err := os.Stat(claimFolder); os.IsNotExist(err) {
+		return nil
+	}
+
+	var recovered, failed int
+	err := filepath.WalkDir(claimFolder, func(claimedPath string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		relPath, err := filepath.Rel(claimFolder, claimedPath)
+		if err != nil {
+			return err
+		}
+		originalPath := filepath.Join(d.monitorFolder(), relPath)
+		if d.isInFlight(originalPath) {
+			return nil
+		}
+		fileLog := d.log.With().Str("claimedPath", claimedPath).Logger()
+
+		switch d.Recovery {
+		case RecoveryPolicyRetry:
+			if _, err := os.Stat(originalPath); err == nil {
+				fileLog.Warn().Str("originalPath", originalPath).Msg("unable to recover claimed file as a file already exists at the original path")
+				failed++
+				return nil
+			}
+			err = os.MkdirAll(filepath.Dir(originalPath), os.ModePerm)
+			if err == nil {
+				err = os.Rename(claimedPath, originalPath)
+			}
+		case RecoveryPolicyError:
+			values, _ := d.templateValues(originalPath, claimedPath, claimFolder, time.Now(), nil)
+			err = d.processError(claimedPath, claimFolder, values)
+			if err == nil {
+				err = os.Remove(claimedPath)
+			}
+		case RecoveryPolicyLeave:
+			fileLog.Warn().Msg("leaving unfinished claimed file")
+			return nil
+		default:
+			return fmt.Errorf("invalid recovery policy: %d", d.Recovery)
+		}
+
+		if err != nil {
+			fileLog.Error().Err(err).Msg("failed to recover claimed file")
+			failed++
+			return nil
+		}
+		fileLog.Info().Msg("recovered claimed file")
+		recovered++
+		d.cleanClaimFolder(claimedPath)
+		return nil
+	})
+
+	if recovered+failed > 0 {
+		d.log.Info().Int("recovered", recovered).Int("failed", failed).Msg("finished recovering claimed files")
+	}
+	return err
+}
@@ -0,0 +1,280 @@
+package fileMonitor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+type ConfigFormat int
+
+const (
+	ConfigFormatJson ConfigFormat = iota
+	ConfigFormatYaml
+	ConfigFormatToml
+)
+
+// Chooses the format from the extension of the config file. Defaults to JSON
+func configFormatOf(configPath string) ConfigFormat {
+	switch strings.ToLower(filepath.Ext(configPath)) {
+	case ".yaml", ".yml":
+		return ConfigFormatYaml
+	case ".toml":
+		return ConfigFormatToml
+	default:
+		return ConfigFormatJson
+	}
+}
+
+// Decodes the config into v migrating it to the current ConfigVersion
+//
+// All formats are converted to JSON so the polymorphic Copier and Processor decoding is shared by all formats
+func (c ConfigFormat) unmarshal(data []byte, v any) error {
+	var document any
+	switch c {
+	case ConfigFormatJson:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		err := decoder.Decode(&document)
+		if err != nil {
+			return err
+		}
+	case ConfigFormatYaml:
+		err := yaml.Unmarshal(data, &document)
+		if err != nil {
+			return fmt.Errorf("unable to unmarshal yaml: %w", err)
+		}
+	case ConfigFormatToml:
+		err := toml.Unmarshal(data, &document)
+		if err != nil {
+			return fmt.Errorf("unable to unmarshal toml: %w", err)
+		}
+	default:
+		return fmt.Errorf("invalid config format: %d", c)
+	}
+
+	if document == nil {
+		document = map[string]any{} // empty yaml document
+	}
+	config, ok := document.(map[string]any)
+	if !ok {
+		return fmt.Errorf("config must be an object but got %T", document)
+	}
+	err := migrateConfig(config)
+	if err != nil {
+		return err
+	}
+
+	data, err = json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("unable to convert config to json: %w", err)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Encodes v in the format using its JSON representation
+func (c ConfigFormat) marshal(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || c == ConfigFormatJson {
+		return data, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var document any
+	err = decoder.Decode(&document)
+	if err != nil {
+		return nil, fmt.Errorf("unable to convert config from json: %w", err)
+	}
+	document = normalizeDocument(document)
+
+	switch c {
+	case ConfigFormatYaml:
+		return yaml.Marshal(document)
+	case ConfigFormatToml:
+		var buffer bytes.Buffer
+		err = toml.NewEncoder(&buffer).Encode(document)
+		return buffer.Bytes(), err
+	default:
+		return nil, fmt.Errorf("invalid config format: %d", c)
+	}
+}
+
+// Converts JSON numbers to integers where possible and drops nulls which TOML cannot represent
+func normalizeDocument(document any) any {
+	switch value := document.(type) {
+	case map[string]any:
+		for key, inner := range value {
+			if inner == nil {
+				delete(value, key)
+				continue
+			}
+			value[key] = normalizeDocument(inner)
+		}
+	case []any:
+		for n, inner := range value {
+			value[n] = normalizeDocument(inner)
+		}
+	case json.Number:
+		if integer, err := value.Int64(); err == nil {
+			return integer
+		}
+		float, _ := value.Float64()
+		return float
+	}
+	return document
+}
+
+// Duration written as a string such as "30s" which can also be read from a number of nanoseconds
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value any
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return err
+	}
+
+	switch value := value.(type) {
+	case float64:
+		*d = Duration(value)
+	case string:
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration: %w", err)
+		}
+		*d = Duration(duration)
+	case nil:
+	default:
+		return fmt.Errorf("invalid duration: %s", data)
+	}
+	return nil
+}
+
+var envReference = regexp.MustCompile(`\$\{(\w+)\}`)
+
+// Expands ${NAME} references to environment variables. Unset variables are left as is
+//
+// Only the braced form is expanded so paths such as Windows admin shares containing "$" are not changed
+func expandEnv(value string) string {
+	return envReference.ReplaceAllStringFunc(value, func(reference string) string {
+		expanded, ok := os.LookupEnv(envReference.FindStringSubmatch(reference)[1])
+		if !ok {
+			return reference
+		}
+		return expanded
+	})
+}
+
+// Replaces a leading "~" with the home folder of the user
+func expandHome(filePath string) string {
+	if filePath != "~" && !strings.HasPrefix(filePath, "~/") && !strings.HasPrefix(filePath, `~\`) {
+		return filePath
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filePath
+	}
+	return filepath.Join(home, filePath[1:])
+}
+
+func (f *FileMonitor) MarshalJSON() ([]byte, error) {
+	type Alias FileMonitor
+	return json.Marshal(&struct {
+		*Alias
+		ReloadFrequency Duration
+	}{
+		Alias:           (*Alias)(f),
+		ReloadFrequency: Duration(f.ReloadFrequency),
+	})
+}
+
+func (f *FileMonitor) UnmarshalJSON(data []byte) error {
+	type Alias FileMonitor
+	aux := &struct {
+		*Alias
+		ReloadFrequency Duration
+	}{
+		Alias:           (*Alias)(f),
+		ReloadFrequency: Duration(f.ReloadFrequency),
+	}
+	err := json.Unmarshal(data, aux)
+	if err != nil {
+		return err
+	}
+	f.ReloadFrequency = time.Duration(aux.ReloadFrequency)
+	return nil
+}
+
+func (s Stability) MarshalJSON() ([]byte, error) {
+	type Alias Stability
+	return json.Marshal(&struct {
+		Alias
+		MinAge Duration
+	}{
+		Alias:  Alias(s),
+		MinAge: Duration(s.MinAge),
+	})
+}
+
+func (s *Stability) UnmarshalJSON(data []byte) error {
+	type Alias Stability
+	aux := &struct {
+		*Alias
+		MinAge Duration
+	}{
+		Alias:  (*Alias)(s),
+		MinAge: Duration(s.MinAge),
+	}
+	err := json.Unmarshal(data, aux)
+	if err != nil {
+		return err
+	}
+	s.MinAge = time.Duration(aux.MinAge)
+	return nil
+}
+
+func (r RetryPolicy) MarshalJSON() ([]byte, error) {
+	type Alias RetryPolicy
+	return json.Marshal(&struct {
+		Alias
+		Backoff    Duration
+		MaxBackoff Duration
+	}{
+		Alias:      Alias(r),
+		Backoff:    Duration(r.Backoff),
+		MaxBackoff: Duration(r.MaxBackoff),
+	})
+}
+
+func (r *RetryPolicy) UnmarshalJSON(data []byte) error {
+	type Alias RetryPolicy
+	aux := &struct {
+		*Alias
+		Backoff    Duration
+		MaxBackoff Duration
+	}{
+		Alias:      (*Alias)(r),
+		Backoff:    Duration(r.Backoff),
+		MaxBackoff: Duration(r.MaxBackoff),
+	}
+	err := json.Unmarshal(data, aux)
+	if err != nil {
+		return err
+	}
+	r.Backoff = time.Duration(aux.Backoff)
+	r.MaxBackoff = time.Duration(aux.MaxBackoff)
+	return nil
+}
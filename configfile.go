@@ -0,0 +1,131 @@
+package fileMonitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Version of the config document written by Update
+const ConfigVersion = 2
+
+// Permissions of the config file which can contain credentials
+const configFileMode os.FileMode = 0600
+
+// Upgrades a decoded config document from one version to the next
+type ConfigMigration func(config map[string]any) error
+
+// Migrations keyed by the version they upgrade from. Add one whenever ConfigVersion is increased
+var configMigrations = map[int]ConfigMigration{
+	0: func(config map[string]any) error { return nil }, // configs from before versioning only gain the Version
+	1: func(config map[string]any) error { return nil }, // plaintext passwords are kept in memory by CopierFtp and dropped on the next save
+}
+
+// Migrates the config document in place to the current ConfigVersion
+func migrateConfig(config map[string]any) error {
+	version := 0
+	if raw, ok := config["Version"]; ok {
+		number, err := strconv.Atoi(fmt.Sprint(raw))
+		if err != nil {
+			return fmt.Errorf("invalid config version: %v", raw)
+		}
+		version = number
+	}
+	if version > ConfigVersion {
+		return fmt.Errorf("config version %d is newer than the supported version %d", version, ConfigVersion)
+	}
+
+	for ; version < ConfigVersion; version++ {
+		migration, ok := configMigrations[version]
+		if !ok {
+			return fmt.Errorf("no migration from config version %d", version)
+		}
+		err := migration(config)
+		if err != nil {
+			return fmt.Errorf("failed to migrate config from version %d: %w", version, err)
+		}
+	}
+	config["Version"] = json.Number(strconv.Itoa(ConfigVersion))
+	return nil
+}
+
+// Writes the file so it is either fully replaced or left unchanged if interrupted
+//
+// The data is written to a temporary file in the same folder, synced and renamed over the file
+func writeFileAtomic(filePath string, data []byte, perm os.FileMode) (err error) {
+	file, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("unable to create temporary file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+
+	_, err = file.Write(data)
+	if err != nil {
+		return fmt.Errorf("unable to write temporary file: %w", err)
+	}
+	err = file.Sync()
+	if err != nil {
+		return fmt.Errorf("unable to sync temporary file: %w", err)
+	}
+	err = file.Close()
+	if err != nil {
+		return fmt.Errorf("unable to close temporary file: %w", err)
+	}
+	err = os.Chmod(file.Name(), perm)
+	if err != nil {
+		return fmt.Errorf("unable to set permissions: %w", err)
+	}
+
+	err = os.Rename(file.Name(), filePath)
+	if err != nil {
+		return fmt.Errorf("unable to replace file: %w", err)
+	}
+	syncDir(filepath.Dir(filePath))
+	return nil
+}
+
+// Syncs the folder so a rename within it is durable. Not supported on all platforms so errors are ignored
+func syncDir(dir string) {
+	file, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = file.Sync()
+	file.Close()
+}
+
+// Path of the nth most recent backup of the config file keeping its extension so the format is still detected
+func configBackupPath(configPath string, n uint) string {
+	ext := filepath.Ext(configPath)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(configPath, ext), n, ext)
+}
+
+// Shifts the existing backups and copies the current config file into the newest backup
+func rotateConfigBackups(configPath string, backups uint) error {
+	if backups == 0 {
+		return nil
+	}
+
+	current, err := os.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("unable to read config for backup: %w", err)
+	}
+
+	for n := backups; n > 1; n-- {
+		err = os.Rename(configBackupPath(configPath, n-1), configBackupPath(configPath, n))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to rotate config backup: %w", err)
+		}
+	}
+	return writeFileAtomic(configBackupPath(configPath, 1), current, configFileMode)
+}
@@ -0,0 +1,148 @@
+package fileMonitor
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// What a copier does when the destination file already exists
+//
+// The destination is checked before copying so files created concurrently by other writers may still be replaced
+type ConflictPolicy int
+
+const (
+	ConflictOverwrite       ConflictPolicy = iota // Replaces the existing file
+	ConflictSkip                                  // Keeps the existing file and skips the copy
+	ConflictFail                                  // Fails the copy with ErrDestinationExists
+	ConflictRenameCounter                         // Copies to the first free name with a counter such as "name_1.csv"
+	ConflictRenameTimestamp                       // Copies to a name with the copy time such as "name_20240102T150405.csv"
+	ConflictKeepIdentical                         // Skips the copy if the existing file has the same SHA-256, otherwise renames with a counter
+)
+
+// Highest counter tried by ConflictRenameCounter before giving up
+const maxConflictCounter = 10000
+
+var ErrDestinationExists = errors.New("destination already exists")
+
+// Destination of a copier checked by a ConflictPolicy
+type conflictTarget interface {
+	exists(name string) (bool, error)
+	open(name string) (io.ReadCloser, error) // only used by ConflictKeepIdentical
+}
+
+func (p ConflictPolicy) validate() error {
+	if p < ConflictOverwrite || p > ConflictKeepIdentical {
+		return fmt.Errorf("invalid conflict policy: %d", p)
+	}
+	return nil
+}
+
+// Picks the name to copy inFilePath to when outFileName may exist. Returns skip if the copy is not needed
+func (p ConflictPolicy) resolve(target conflictTarget, outFileName, inFilePath string) (name string, skip bool, err error) {
+	if p == ConflictOverwrite {
+		return outFileName, false, nil
+	}
+
+	exists, err := target.exists(outFileName)
+	if err != nil {
+		return "", false, fmt.Errorf("unable to check destination: %w", err)
+	} else if !exists {
+		return outFileName, false, nil
+	}
+
+	switch p {
+	case ConflictSkip:
+		return "", true, nil
+	case ConflictFail:
+		return "", false, fmt.Errorf("%w: %s", ErrDestinationExists, outFileName)
+	case ConflictRenameCounter:
+		name, err = freeConflictName(target, outFileName, "")
+	case ConflictRenameTimestamp:
+		name, err = freeConflictName(target, outFileName, "_"+time.Now().Format("20060102T150405"))
+	case ConflictKeepIdentical:
+		var identical bool
+		identical, err = sameSha256(target, outFileName, inFilePath)
+		if err != nil {
+			return "", false, err
+		} else if identical {
+			return "", true, nil
+		}
+		name, err = freeConflictName(target, outFileName, "")
+	default:
+		return "", false, fmt.Errorf("invalid conflict policy: %d", p)
+	}
+	return name, false, err
+}
+
+// Adds the suffix before the extension and if still taken a counter until the name is free
+func freeConflictName(target conflictTarget, outFileName, suffix string) (string, error) {
+	ext := filepath.Ext(outFileName)
+	base := strings.TrimSuffix(outFileName, ext) + suffix
+
+	if suffix != "" {
+		exists, err := target.exists(base + ext)
+		if err != nil {
+			return "", fmt.Errorf("unable to check destination: %w", err)
+		} else if !exists {
+			return base + ext, nil
+		}
+	}
+	for n := 1; n <= maxConflictCounter; n++ {
+		name := fmt.Sprintf("%s_%d%s", base, n, ext)
+		exists, err := target.exists(name)
+		if err != nil {
+			return "", fmt.Errorf("unable to check destination: %w", err)
+		} else if !exists {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("no free name found for %s", outFileName)
+}
+
+// Compares the SHA-256 of the existing destination file with the local file
+func sameSha256(target conflictTarget, outFileName, inFilePath string) (bool, error) {
+	inFile, err := os.Open(inFilePath)
+	if err != nil {
+		return false, fmt.Errorf("failed to open the file: %w", err)
+	}
+	defer inFile.Close()
+	inHash := sha256.New()
+	_, err = io.Copy(inHash, inFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to read the file: %w", err)
+	}
+
+	outFile, err := target.open(outFileName)
+	if err != nil {
+		return false, fmt.Errorf("unable to open existing file: %w", err)
+	}
+	defer outFile.Close()
+	outHash := sha256.New()
+	_, err = io.Copy(outHash, outFile)
+	if err != nil {
+		return false, fmt.Errorf("unable to read existing file: %w", err)
+	}
+	return bytes.Equal(inHash.Sum(nil), outHash.Sum(nil)), nil
+}
+
+// Files in a local or mounted folder
+type localConflictTarget struct{}
+
+func (localConflictTarget) exists(name string) (bool, error) {
+	_, err := os.Stat(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (localConflictTarget) open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
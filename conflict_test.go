@@ -0,0 +1,102 @@
+package fileMonitor
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestConflictPolicy(t *testing.T) {
+	t.Parallel()
+
+	monitorFolder := t.TempDir()
+	filePath := filepath.Join(monitorFolder, "local.csv")
+	err := os.WriteFile(filePath, []byte("a,b\n1,2\n"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	// writes the existing file and copies with the policy
+	copyWith := func(policy ConflictPolicy, existing string) (string, error) {
+		destination := t.TempDir()
+		err := os.WriteFile(filepath.Join(destination, "local.csv"), []byte(existing), os.ModePerm)
+		if err != nil {
+			t.Fatalf("failed to write existing file: %v", err)
+		}
+		copier := &CopierLocal{Destination: destination, OnConflict: policy}
+		return destination, copier.Copy(filePath, monitorFolder)
+	}
+	readFile := func(filePath string) string {
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			t.Errorf("failed to read %s: %v", filePath, err)
+		}
+		return string(data)
+	}
+	countFiles := func(dir string) int {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("failed to read destination: %v", err)
+		}
+		return len(entries)
+	}
+
+	destination, err := copyWith(ConflictOverwrite, "previous")
+	if err != nil || readFile(filepath.Join(destination, "local.csv")) != "a,b\n1,2\n" {
+		t.Errorf("expected the existing file to be replaced: %v", err)
+	}
+
+	destination, err = copyWith(ConflictSkip, "previous")
+	if err != nil || readFile(filepath.Join(destination, "local.csv")) != "previous" || countFiles(destination) != 1 {
+		t.Errorf("expected the copy to be skipped: %v", err)
+	}
+
+	destination, err = copyWith(ConflictFail, "previous")
+	if !errors.Is(err, ErrDestinationExists) {
+		t.Errorf("expected ErrDestinationExists but got %v", err)
+	}
+	if readFile(filepath.Join(destination, "local.csv")) != "previous" {
+		t.Errorf("existing file should not have been replaced")
+	}
+
+	destination, err = copyWith(ConflictRenameCounter, "previous")
+	if err != nil || readFile(filepath.Join(destination, "local_1.csv")) != "a,b\n1,2\n" {
+		t.Errorf("expected a copy with a counter: %v", err)
+	}
+	copier := &CopierLocal{Destination: destination, OnConflict: ConflictRenameCounter}
+	err = copier.Copy(filePath, monitorFolder)
+	if err != nil || readFile(filepath.Join(destination, "local_2.csv")) != "a,b\n1,2\n" {
+		t.Errorf("expected the next free counter: %v", err)
+	}
+
+	destination, err = copyWith(ConflictRenameTimestamp, "previous")
+	if err != nil {
+		t.Errorf("failed to copy with a timestamp: %v", err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(destination, "local_*.csv"))
+	if len(matches) != 1 || !regexp.MustCompile(`local_\d{8}T\d{6}\.csv$`).MatchString(matches[0]) {
+		t.Errorf("expected a copy with a timestamp but got %v", matches)
+	}
+
+	destination, err = copyWith(ConflictKeepIdentical, "a,b\n1,2\n")
+	if err != nil || countFiles(destination) != 1 {
+		t.Errorf("expected an identical copy to be skipped: %v", err)
+	}
+	destination, err = copyWith(ConflictKeepIdentical, "previous")
+	if err != nil || readFile(filepath.Join(destination, "local_1.csv")) != "a,b\n1,2\n" {
+		t.Errorf("expected a different file to be renamed: %v", err)
+	}
+
+	copier = &CopierLocal{Destination: t.TempDir(), OnConflict: ConflictFail}
+	err = copier.Copy(filePath, monitorFolder)
+	if err != nil || countFiles(copier.Destination) != 1 {
+		t.Errorf("expected a copy without an existing file: %v", err)
+	}
+
+	copier = &CopierLocal{Destination: t.TempDir(), OnConflict: ConflictPolicy(42)}
+	if copier.Validate() == nil {
+		t.Errorf("expected an invalid policy to fail validation")
+	}
+}
@@ -1,29 +1,61 @@
 package fileMonitor
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-
-	"github.com/jlaffaye/ftp"
 )
 
-type CopierType int
+// Name a copier type is registered with
+type CopierType string
 
 const (
-	CopierTypeNull = iota
-	CopierTypeLocal
-	CopierTypeFTP
+	CopierTypeNull  CopierType = ""
+	CopierTypeLocal CopierType = "local"
+	CopierTypeFTP   CopierType = "ftp"
+	CopierTypeSmb   CopierType = "smb"
+	CopierTypeSftp  CopierType = "sftp"
+	CopierTypeS3    CopierType = "s3"
 )
 
+// Indexed by the integer types used by older configs
+var legacyCopierTypes = []string{string(CopierTypeNull), string(CopierTypeLocal), string(CopierTypeFTP)}
+
+func (c *CopierType) UnmarshalJSON(data []byte) error {
+	name, err := unmarshalTypeName(data, legacyCopierTypes)
+	*c = CopierType(name)
+	return err
+}
+
 type Copier interface {
 	Copy(dir, monitorDir string) error
 	GetType() CopierType
 	MarshalJSON() ([]byte, error) // Must inject type into object
 }
 
+// Closes the copiers holding connections, such as pools, once the dir is removed or replaced
+//
+// Copiers implement io.Closer to be closed. A copier must still work after being closed as files already queued may
+// be copied by it
+func (d *Dir) closeCopiers() {
+	for _, copiers := range [][]Copier{d.Copiers, d.ErrorCopiers} {
+		for _, copier := range copiers {
+			closer, ok := copier.(io.Closer)
+			if !ok {
+				continue
+			}
+			err := closer.Close()
+			if err != nil {
+				d.log.Warn().Err(err).Str("copier", string(copier.GetType())).Msg("failed to close copier")
+			}
+		}
+	}
+}
+
 type CopierAlias struct {
 	Type    CopierType
 	Details json.RawMessage
@@ -59,20 +91,15 @@ func (c *CopierAlias) UnmarshalJSON(data []byte) error {
 }
 
 func (c *CopierAlias) GetCopier() (Copier, error) {
-	switch c.Type {
-	case CopierTypeNull:
+	if c.Type == CopierTypeNull {
 		return nil, fmt.Errorf("no type provided")
-	case CopierTypeLocal:
-		copier := &CopierLocal{}
-		err := json.Unmarshal(c.Details, copier)
-		return copier, err
-	case CopierTypeFTP:
-		copier := &CopierFtp{}
-		err := json.Unmarshal(c.Details, copier)
-		return copier, err
-	default:
-		return nil, fmt.Errorf("invalid type: %d", c.Type)
 	}
+
+	copier, err := newCopier(c.Type)
+	if err != nil {
+		return nil, err
+	}
+	return copier, json.Unmarshal(c.Details, copier)
 }
 
 func getOutFileName(inFilePath, monitorDir, destination string) string {
@@ -83,121 +110,132 @@ func getOutFileName(inFilePath, monitorDir, destination string) string {
 	return filepath.Join(destination, inFilePath[len(monitorDir):])
 }
 
+// Copies files into a local or mounted folder
+//
+// Files are written to a temporary file in the destination folder, synced to disk and renamed into place so readers
+// never see partial files. Permission bits, modification time and where permitted the owner and extended attributes
+// are preserved
 type CopierLocal struct {
-	Destination string
+	Destination    string
+	VerifyChecksum bool           // Compares the SHA-256 of the copy read back from disk with the source
+	OnConflict     ConflictPolicy // What happens if the destination file exists. Overwrites by default
+	PathTemplate   PathTemplate
 }
 
 func (c *CopierLocal) Copy(filePath, monitorDir string) error {
-	outFileName := getOutFileName(filePath, monitorDir, c.Destination)
+	return c.CopyTemplated(filePath, monitorDir, nil)
+}
 
-	err := os.MkdirAll(filepath.Dir(outFileName), os.ModePerm)
+func (c *CopierLocal) CopyTemplated(filePath, monitorDir string, values *TemplateValues) error {
+	outFileName, err := c.PathTemplate.outFileName(filePath, monitorDir, expandEnv(c.Destination), values)
 	if err != nil {
-		return fmt.Errorf("unable to create directory: %w", err)
+		return err
 	}
 
-	outFile, err := os.Create(outFileName)
+	err = os.MkdirAll(filepath.Dir(outFileName), os.ModePerm)
 	if err != nil {
-		outFile.Close()
-		return fmt.Errorf("error creating file: %w", err)
+		return fmt.Errorf("unable to create directory: %w", err)
+	}
+	outFileName, skip, err := c.OnConflict.resolve(localConflictTarget{}, outFileName, filePath)
+	if err != nil || skip {
+		return err
 	}
 
 	inFile, err := os.Open(filePath)
 	if err != nil {
 		return fmt.Errorf("error opening file: %w", err)
 	}
+	defer inFile.Close()
+	inStats, err := inFile.Stat()
+	if err != nil {
+		return fmt.Errorf("error getting file stats: %w", err)
+	}
 
-	_, err = io.Copy(outFile, inFile)
+	outFile, err := os.CreateTemp(filepath.Dir(outFileName), filepath.Base(outFileName)+".*.part")
 	if err != nil {
-		inFile.Close()
+		return fmt.Errorf("error creating file: %w", err)
+	}
+	defer os.Remove(outFile.Name()) // fails once renamed into place
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(outFile, hash), inFile)
+	if err != nil {
+		outFile.Close()
 		return fmt.Errorf("failed to copy file: %w", err)
 	}
 
-	err = inFile.Close()
+	err = outFile.Chmod(inStats.Mode().Perm())
+	if err != nil {
+		outFile.Close()
+		return fmt.Errorf("unable to set permissions: %w", err)
+	}
+	err = copyFileMetadata(inFile, outFile, inStats)
 	if err != nil {
-		return fmt.Errorf("error closing inFile: %w", err)
+		outFile.Close()
+		return err
 	}
 
+	err = outFile.Sync()
+	if err != nil {
+		outFile.Close()
+		return fmt.Errorf("failed to sync outFile: %w", err)
+	}
 	err = outFile.Close()
 	if err != nil {
 		return fmt.Errorf("failed to close outFile: %w", err)
 	}
 
+	if c.VerifyChecksum {
+		err = verifySha256(outFile.Name(), hash.Sum(nil))
+		if err != nil {
+			return err
+		}
+	}
+
 	// Ignore errors as they are not critical
-	creationTime, err := getCreationTime(inFile.Name())
+	creationTime, err := getCreationTime(filePath)
 	if err == nil {
 		setCreationTime(outFile.Name(), creationTime)
 	}
 
-	return nil
-}
-
-func (c *CopierLocal) GetType() CopierType {
-	return CopierTypeLocal
-}
-
-func (c *CopierLocal) MarshalJSON() ([]byte, error) {
-	type Alias CopierLocal
-	return json.Marshal(&struct {
-		Type CopierType `json:"Type"`
-		*Alias
-	}{
-		Type:  c.GetType(),
-		Alias: (*Alias)(c),
-	})
-}
-
-type CopierFtp struct {
-	Server      string
-	Username    string
-	Password    string // Does not store the password directly and only stores encrypted using the encryptionFunc
-	Destination string
-
-	EncryptionFunc func(string) (string, error) `json:"-"` // Not stored in JSON
-	DecryptionFunc func(string) (string, error) `json:"-"` // Not stored in JSON
-}
-
-func (c *CopierFtp) Copy(inFilePath, monitorDir string) error {
-	if c.EncryptionFunc == nil || c.DecryptionFunc == nil {
-		return fmt.Errorf("must supply both an encryption and decryption function")
-	}
-
-	conn, err := ftp.Dial(c.Server)
+	err = os.Rename(outFile.Name(), outFileName)
 	if err != nil {
-		return fmt.Errorf("failed to connect to FTP server: %w", err)
+		return fmt.Errorf("unable to rename file into place: %w", err)
 	}
-	defer conn.Quit()
+	syncDir(filepath.Dir(outFileName))
+	return nil
+}
 
-	err = conn.Login(c.Username, c.Password)
+// Checks the SHA-256 of the file matches the expected sum
+func verifySha256(filePath string, expected []byte) error {
+	file, err := os.Open(filePath)
 	if err != nil {
-		return fmt.Errorf("failed to log in to FTP server: %w", err)
+		return fmt.Errorf("unable to open file to verify: %w", err)
 	}
+	defer file.Close()
 
-	inFile, err := os.Open(inFilePath)
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
 	if err != nil {
-		return fmt.Errorf("failed to open the file: %w", err)
+		return fmt.Errorf("unable to read file to verify: %w", err)
 	}
-	defer inFile.Close()
-
-	outFileName := getOutFileName(inFilePath, monitorDir, c.Destination)
-	err = conn.Stor(outFileName, inFile)
-	if err != nil {
-		return fmt.Errorf("failed to upload file to FTP server: %w", err)
+	if !bytes.Equal(hash.Sum(nil), expected) {
+		return fmt.Errorf("checksum of copy does not match the source")
 	}
-
 	return nil
 }
 
-func (c *CopierFtp) GetType() CopierType {
-	return CopierTypeFTP
+func (c *CopierLocal) GetType() CopierType {
+	return CopierTypeLocal
 }
 
-func (f *CopierFtp) MarshalJSON() ([]byte, error) {
-	type Alias CopierFtp
+func (c *CopierLocal) MarshalJSON() ([]byte, error) {
+	type Alias CopierLocal
 	return json.Marshal(&struct {
 		Type CopierType `json:"Type"`
 		*Alias
 	}{
-		Type:  f.GetType(),
-		Alias: (*Alias)(f),
+		Type:  c.GetType(),
+		Alias: (*Alias)(c),
 	})
 }
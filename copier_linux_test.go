@@ -0,0 +1,38 @@
+//go:build linux
+
+package fileMonitor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestCopierLocalXattrs(t *testing.T) {
+	t.Parallel()
+
+	monitorFolder := t.TempDir()
+	filePath := filepath.Join(monitorFolder, "xattr.csv")
+	err := os.WriteFile(filePath, []byte("a,b\n1,2\n"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	err = unix.Setxattr(filePath, "user.instrument", []byte("hplc-1"), 0)
+	if err != nil {
+		t.Skipf("extended attributes not supported: %v", err)
+	}
+
+	destination := t.TempDir()
+	err = (&CopierLocal{Destination: destination}).Copy(filePath, monitorFolder)
+	if err != nil {
+		t.Fatalf("failed to copy file: %v", err)
+	}
+
+	value := make([]byte, 64)
+	size, err := unix.Getxattr(filepath.Join(destination, "xattr.csv"), "user.instrument", value)
+	if err != nil || string(value[:size]) != "hplc-1" {
+		t.Errorf("expected the extended attribute to be copied but got %q: %v", value[:size], err)
+	}
+}
@@ -0,0 +1,100 @@
+package fileMonitor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCopierLocal(t *testing.T) {
+	t.Parallel()
+
+	monitorFolder := t.TempDir()
+	filePath := filepath.Join(monitorFolder, "sub", "local.csv")
+	err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to create sub folder: %v", err)
+	}
+	err = os.WriteFile(filePath, []byte("a,b\n1,2\n"), 0640)
+	if err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	err = os.Chmod(filePath, 0640) // not affected by the umask
+	if err != nil {
+		t.Fatalf("failed to change mode: %v", err)
+	}
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	err = os.Chtimes(filePath, modTime, modTime)
+	if err != nil {
+		t.Fatalf("failed to change times: %v", err)
+	}
+
+	destination := t.TempDir()
+	outFileName := filepath.Join(destination, "sub", "local.csv")
+	err = os.MkdirAll(filepath.Dir(outFileName), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to create destination: %v", err)
+	}
+	err = os.WriteFile(outFileName, []byte("previous content which is longer"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write existing file: %v", err)
+	}
+
+	copier := &CopierLocal{Destination: destination, VerifyChecksum: true}
+	err = copier.Copy(filePath, monitorFolder)
+	if err != nil {
+		t.Fatalf("failed to copy file: %v", err)
+	}
+
+	data, err := os.ReadFile(outFileName)
+	if err != nil || string(data) != "a,b\n1,2\n" {
+		t.Errorf("unexpected copied file: %q, %v", data, err)
+	}
+	outStats, err := os.Stat(outFileName)
+	if err != nil {
+		t.Fatalf("failed to stat copied file: %v", err)
+	}
+	if outStats.Mode().Perm() != 0640 && os.PathSeparator == '/' {
+		t.Errorf("expected permissions to be preserved but got %v", outStats.Mode().Perm())
+	}
+	if !outStats.ModTime().Equal(modTime) {
+		t.Errorf("expected modification time %v but got %v", modTime, outStats.ModTime())
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(outFileName))
+	if err != nil {
+		t.Fatalf("failed to read destination: %v", err)
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".part") {
+			t.Errorf("temporary file should have been renamed: %s", entry.Name())
+		}
+	}
+}
+
+func TestCopierLocalValidate(t *testing.T) {
+	t.Parallel()
+
+	destination := filepath.Join(t.TempDir(), "nested", "destination")
+	copier := &CopierLocal{Destination: destination}
+	err := copier.Validate()
+	if err != nil {
+		t.Errorf("expected a missing destination to be valid but got: %v", err)
+	}
+	_, err = os.Stat(filepath.Dir(destination))
+	if !os.IsNotExist(err) {
+		t.Errorf("validate should not create the destination: %v", err)
+	}
+
+	notFolder := filepath.Join(t.TempDir(), "file")
+	err = os.WriteFile(notFolder, nil, os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	copier = &CopierLocal{Destination: filepath.Join(notFolder, "destination")}
+	if copier.Validate() == nil {
+		t.Errorf("expected a destination within a file to fail")
+	}
+}
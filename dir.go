@@ -4,11 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io/fs"
+	"maps"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/treavorj/zerolog"
@@ -38,14 +41,35 @@ func (m *MatchGroup) Match(filePath string) (match bool, err error) {
 
 type Dir struct {
 	Name             string
-	MonitorFolder    string
+	MonitorFolder    string // Local folder or URL of a registered Source such as "smb://user@server/share/folder"
 	MonitorFrequency time.Duration
-	KeepEmptyDirs    bool
-	Active           bool
+	WatcherType      WatcherType
+	KeepEmptyDirs    bool // Never removes empty subfolders. Overrides RemoveEmptyDirs
+	RemoveEmptyDirs  bool // Removes subfolders of the MonitorFolder once they stayed empty for a full MonitorFrequency
+	Active           bool // Saved with the config. Use IsActive as it is not updated while running
+	Paused           bool // No new files are scheduled while paused. Use Pause, Resume and IsPaused while running
 	MatchGroups      []MatchGroup
+	Stability        Stability // Files are held as pending until the policy considers them no longer being written
 
+	Claim    bool           // Moves files into a ".processing" folder within the MonitorFolder before they are processed
+	Recovery RecoveryPolicy // Handling of claimed files left unfinished by a previous run
+
+	Duplicates DuplicatePolicy // Handling of files already completed according to the FileMonitor ledger
+	Retry      RetryPolicy     // Reattempts failed publish and copy stages before sending the file to the ErrorCopiers
+
+	OnSuccess Disposition // What happens to the source file once processed successfully. Deletes by default
+	OnFailure Disposition // What happens to the source file after the ErrorCopiers. Deletes by default
+
+	// Failed files are moved here with a JSON sidecar describing the failure instead of applying OnFailure
+	QuarantineFolder string
+
+	// Local folder files of a remote MonitorFolder are downloaded into and processed from. MatchGroups are checked
+	// against paths within it. Defaults to a folder named after the dir within the temp folder
+	StagingFolder string
+
+	Watcher    Watcher `json:"-"` // Overrides WatcherType if provided
 	Processor  *Processor
-	Publishers []Publisher `json:"-"`
+	Publishers []Publisher // Only publishers of types registered with RegisterPublisher are saved
 	Copiers    []Copier
 
 	// Copier to use if an error occurs after a match as original file will be delete
@@ -53,28 +77,104 @@ type Dir struct {
 
 	Stats Stats
 
-	log       zerolog.Logger
-	ctx       context.Context
-	ctxCancel context.CancelFunc
+	publishersLock sync.RWMutex // guards Publishers as FileMonitor.AddAllDirPublisher adds to them while running
+
+	pendingLock   sync.Mutex
+	pending       map[string]*pendingFile // keyed by file path
+	remotePending map[string]*pendingFile // keyed by path within the Source
+
+	inFlight *inFlightSet
+
+	emptyLock  sync.Mutex
+	emptySince map[string]time.Time // subfolders found empty keyed by path. Only removed once empty for a MonitorFrequency
+
+	pauseLock sync.Mutex  // guards Paused as it is changed while running
+	active    atomic.Bool // set while the watcher is running
+
+	sourceLock sync.Mutex
+	source     Source   // connection to a remote MonitorFolder. Only used through withSource
+	secrets    *Secrets // set by useSecrets to resolve references of the Source
+
+	leftLock sync.Mutex
+	left     map[string]leftFile // files left in place when no ledger is configured
+
+	log         zerolog.Logger
+	ctx         context.Context
+	ctxCancel   context.CancelFunc
+	monitorDone chan struct{} // closed once the watcher stopped
 
 	parent *FileMonitor
 }
 
+// Adds the publisher so it is used for files processed from now on, including while the dir is running
+func (d *Dir) addPublisher(publisher Publisher) {
+	d.publishersLock.Lock()
+	defer d.publishersLock.Unlock()
+	d.Publishers = append(d.Publishers, publisher)
+}
+
+// Current publishers. The returned slice is not changed by later additions
+func (d *Dir) publishers() []Publisher {
+	d.publishersLock.RLock()
+	defer d.publishersLock.RUnlock()
+	return d.Publishers
+}
+
+func (d *Dir) MarshalJSON() ([]byte, error) {
+	type Alias Dir
+	publishers, err := marshalPublishers(d.publishers())
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(&struct {
+		*Alias
+		Active           bool
+		Paused           bool
+		MonitorFrequency Duration
+		Publishers       []json.RawMessage
+	}{
+		Alias:            (*Alias)(d),
+		Active:           d.IsActive(),
+		Paused:           d.IsPaused(),
+		MonitorFrequency: Duration(d.MonitorFrequency),
+		Publishers:       publishers,
+	})
+}
+
+// Checks if the dir is being monitored
+func (d *Dir) IsActive() bool {
+	return d.active.Load()
+}
+
 func (d *Dir) UnmarshalJSON(input []byte) error {
 	type Alias Dir
 	aux := &struct {
 		*Alias
 
-		Copiers      []CopierAlias
-		ErrorCopiers []CopierAlias
+		MonitorFrequency Duration
+		Publishers       []PublisherAlias
+		Copiers          []CopierAlias
+		ErrorCopiers     []CopierAlias
 	}{
-		Alias: (*Alias)(d),
+		Alias:            (*Alias)(d),
+		MonitorFrequency: Duration(d.MonitorFrequency),
 	}
 
 	var err error
 	if err = json.Unmarshal(input, &aux); err != nil {
 		return err
 	}
+	d.MonitorFrequency = time.Duration(aux.MonitorFrequency)
+
+	publishers := make([]Publisher, len(aux.Publishers))
+	for n := range aux.Publishers {
+		publishers[n], err = aux.Publishers[n].GetPublisher()
+		if err != nil {
+			return fmt.Errorf("unable to get type for publisher: %w", err)
+		}
+	}
+	d.Publishers = publishers
 
 	copiers := make([]Copier, len(aux.Copiers))
 	for n := range aux.Copiers {
@@ -97,45 +197,232 @@ func (d *Dir) UnmarshalJSON(input []byte) error {
 	return nil
 }
 
+// MonitorFolder with environment variables expanded or the StagingFolder if it is remote
+func (d *Dir) monitorFolder() string {
+	if d.isRemote() {
+		return d.stagingFolder()
+	}
+	return expandEnv(d.MonitorFolder)
+}
+
 func (d *Dir) Monitor() error {
-	d.log = d.parent.logger.With().Str("monitorFolder", d.MonitorFolder).DeDup().Logger()
+	if d.MonitorFrequency <= 0 {
+		return fmt.Errorf("MonitorFrequency must be greater than 0: %v", d.MonitorFrequency)
+	}
+	err := d.Retry.compile()
+	if err != nil {
+		return err
+	}
+	if d.isRemote() {
+		err = d.prepareStaging()
+		if err != nil {
+			return err
+		}
+	}
+	d.log = d.parent.logger.With().Str("monitorFolder", d.monitorFolder()).DeDup().Logger()
 	d.Stats = Stats{}
-	d.log.Info().Msg("starting monitor")
+	d.pendingLock.Lock()
+	d.pending = make(map[string]*pendingFile)
+	d.remotePending = make(map[string]*pendingFile)
+	d.pendingLock.Unlock()
+	if d.inFlight == nil {
+		d.inFlight = newInFlightSet()
+	}
+	d.log.Info().Bool("paused", d.IsPaused()).Msg("starting monitor")
 	d.ctx, d.ctxCancel = context.WithCancel(d.parent.ctx)
 
+	d.useSecrets(d.parent.secrets)
+	d.warnPlaintextPasswords()
+	err = d.recoverClaimed()
+	if err != nil {
+		d.log.Error().Err(err).Msg("failed to recover claimed files")
+	}
+	if d.OnSuccess.Action == DispositionDelete && d.Processor == nil && len(d.Copiers) == 0 {
+		d.log.Warn().Msg("no processor or copiers configured so matching files will be deleted without being used")
+	}
+	if (d.OnSuccess.Action == DispositionLeave || d.OnFailure.Action == DispositionLeave) && d.parent.ledger == nil {
+		d.log.Warn().Msg("files left in place are only remembered in memory without a ledger and will be processed again after a restart")
+	}
+
+	d.monitorDone = make(chan struct{})
+	d.active.Store(true)
 	go d.monitor()
 	return nil
 }
 
+// Stops scanning the dir and waits for the watcher to finish
+//
+// Files already queued or being processed are still finished by the workers
+func (d *Dir) Stop() {
+	if d.ctxCancel == nil {
+		return
+	}
+	d.ctxCancel()
+	<-d.monitorDone
+}
+
 func (d *Dir) monitor() {
-	d.Active = true
 	defer func() {
-		d.Active = false
+		d.active.Store(false)
+		close(d.monitorDone)
 	}()
 
-	ticker := time.NewTicker(d.MonitorFrequency)
-	defer ticker.Stop()
+	watcher := d.Watcher
+	if watcher == nil {
+		var err error
+		watcher, err = d.WatcherType.newWatcher()
+		if err != nil {
+			d.log.Warn().Err(err).Msg("unable to create watcher, falling back to polling")
+			watcher = &watcherPoll{}
+		}
+	}
 
-	for {
-		select {
-		case <-ticker.C:
-			startRead := time.Now()
-			d.log.Trace().Time("startRead", startRead).Msg("checking for file changes")
-			err := d.readDir(d.MonitorFolder, true)
-			if err != nil {
-				d.log.Error().Err(err).Dur("processTime", time.Since(startRead)).Msg("failed to read directory")
-				continue
-			}
-			d.log.Trace().Dur("processTime", time.Since(startRead)).Msg("finished reading directory")
-		case <-d.ctx.Done():
-			d.log.Info().Msg("stopping monitor")
+	if d.isRemote() {
+		pulled := make(chan struct{})
+		go func() {
+			defer close(pulled)
+			d.pullSource()
+		}()
+		defer func() { <-pulled }()
+	}
+
+	err := watcher.Watch(d.ctx, d)
+	if err != nil && d.ctx.Err() == nil {
+		d.log.Error().Err(err).Msg("watcher failed, falling back to polling")
+		_ = (&watcherPoll{}).Watch(d.ctx, d)
+	}
+	d.log.Info().Msg("stopping monitor")
+}
+
+// Reads the entire monitor folder and schedules every file found
+func (d *Dir) Rescan() error {
+	startRead := time.Now()
+	d.log.Trace().Time("startRead", startRead).Msg("checking for file changes")
+	err := d.readDir(d.monitorFolder(), true)
+	if err != nil {
+		d.log.Error().Err(err).Dur("processTime", time.Since(startRead)).Msg("failed to read directory")
+		return err
+	}
+	d.prunePending(startRead)
+	d.log.Trace().Dur("processTime", time.Since(startRead)).Msg("finished reading directory")
+	return nil
+}
+
+// Schedules a single file within dir to be processed by the workers
+//
+// Files not matching the MatchGroups are ignored and files that are not yet stable are held as pending
+func (d *Dir) Schedule(dir, name string) {
+	filePath := filepath.Join(dir, name)
+	fileLog := d.log.With().Str("filename", name).Str("dir", dir).Logger()
+	if d.ctx == nil || d.ctx.Err() != nil {
+		fileLog.Trace().Msg("dir is stopped")
+		return
+	} else if d.IsPaused() {
+		fileLog.Trace().Msg("dir is paused")
+		return
+	}
+
+	match, err := d.match(filePath)
+	if err != nil {
+		fileLog.Warn().Err(err).Msg("error matching file")
+		d.quarantineUnscheduled(dir, name, FailureStageMatch, err)
+		return
+	} else if !match || d.isRenamed(name) {
+		return
+	}
+
+	if d.isInFlight(filePath) {
+		fileLog.Trace().Msg("file already scheduled")
+		d.Stats.SkippedInFlight.Add(1)
+		return
+	}
+	if d.wasLeft(filePath) {
+		return
+	}
+
+	// staged files are complete and their stability was checked on the source
+	if !d.isRemote() {
+		stable, err := d.isStable(filePath)
+		if err != nil {
+			fileLog.Trace().Err(err).Msg("unable to check file stability")
+			return
+		} else if !stable {
+			fileLog.Trace().Msg("file is not yet stable")
 			return
 		}
+	}
+
+	if !d.acquire(filePath) {
+		fileLog.Trace().Msg("file already scheduled")
+		d.Stats.SkippedInFlight.Add(1)
+		return
+	}
+
+	task := &fileTask{dir: dir, name: name}
+	if !d.parent.queue(d.ctx, func(worker uint) { d.runTask(worker, task) }) {
+		d.release(filePath)
+	}
+}
+
+// Removes dir with RemoveEmptyDirs once it stayed empty for a full MonitorFrequency
+//
+// Folders are only remembered the first time they are found empty as writers may create a folder before writing the
+// file into it
+func (d *Dir) removeIfEmpty(dir string) {
+	if !d.RemoveEmptyDirs || d.KeepEmptyDirs || filepath.Clean(dir) == filepath.Clean(d.monitorFolder()) {
+		return
+	}
+
+	files, err := os.ReadDir(dir)
+	d.emptyLock.Lock()
+	defer d.emptyLock.Unlock()
+	if err != nil || len(files) != 0 {
+		delete(d.emptySince, dir)
+		return
+	}
+	since, ok := d.emptySince[dir]
+	if !ok {
+		if d.emptySince == nil {
+			d.emptySince = make(map[string]time.Time)
+		}
+		d.emptySince[dir] = time.Now()
+		return
+	} else if time.Since(since) < d.MonitorFrequency {
+		return
+	}
+
+	delete(d.emptySince, dir)
+	err = os.Remove(dir)
+	if err != nil {
+		d.log.Error().Err(err).Str("dir", dir).Msg("failed to delete empty directory")
+		return
+	}
+	d.log.Trace().Str("dir", dir).Msg("deleted empty directory")
+}
+
+// Restarts the wait before removing dir as something was written into it
+func (d *Dir) forgetEmpty(dir string) {
+	d.emptyLock.Lock()
+	defer d.emptyLock.Unlock()
+	delete(d.emptySince, dir)
+}
 
+// Checks the folders found empty again. Used by watchers which do not rescan every MonitorFrequency
+func (d *Dir) removeEmptyDirs() {
+	d.emptyLock.Lock()
+	dirs := slices.Collect(maps.Keys(d.emptySince))
+	d.emptyLock.Unlock()
+
+	for _, dir := range dirs {
+		d.removeIfEmpty(dir)
 	}
 }
 
 func (d *Dir) readDir(dir string, root bool) error {
+	if d.isInternalFolder(dir) {
+		return nil
+	}
+
 	files, err := os.ReadDir(dir)
 	if err != nil {
 		d.log.Error().Err(err).Str("dir", dir).Msg("failed to read directory")
@@ -143,56 +430,117 @@ func (d *Dir) readDir(dir string, root bool) error {
 	}
 	if len(files) == 0 {
 		d.log.Trace().Str("dir", dir).Msg("no files found")
-		if root || !d.KeepEmptyDirs {
-			return nil
+		if !root {
+			d.removeIfEmpty(dir)
 		}
-		err := os.Remove(dir)
-		if err != nil {
-			d.log.Error().Err(err).Str("dir", dir).Msg("failed to delete empty directory")
-			return err
-		}
-		d.log.Trace().Str("dir", dir).Msg("deleted empty directory")
 		return nil
 	}
+	d.forgetEmpty(dir)
 	for _, fileInfo := range files {
 		if fileInfo.IsDir() {
 			_ = d.readDir(filepath.Join(dir, fileInfo.Name()), false)
 		} else {
-			d.parent.workerTasks <- func(worker uint) {
-				d.processFiles(worker, fileInfo, dir)
-			}
+			d.Schedule(dir, fileInfo.Name())
 		}
 	}
 	return nil
 }
 
-func (d *Dir) processFiles(worker uint, fileInfo fs.DirEntry, dir string) {
+// Checks the file against all MatchGroups
+func (d *Dir) match(filePath string) (bool, error) {
+	for _, matchGroup := range d.MatchGroups {
+		match, err := matchGroup.Match(filePath)
+		if err != nil || !match {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// File being processed which is kept between attempts
+type fileTask struct {
+	dir           string // original directory within the MonitorFolder
+	name          string
+	filePath      string // current location of the file which changes once claimed
+	monitorFolder string // folder filePath is relative to for copiers
+	attempt       uint
+	worker        uint      // worker of the current attempt
+	started       time.Time // start of the current attempt
+	record        *LedgerRecord
+	failure       *Failure
+}
+
+func (t *fileTask) originalPath() string {
+	return filepath.Join(t.dir, t.name)
+}
+
+// Processes the task and releases the file unless it is parked for a retry
+func (d *Dir) runTask(worker uint, task *fileTask) {
+	if d.parent.ctx.Err() != nil {
+		d.release(task.originalPath()) // dropped while stopping
+		return
+	}
+	if d.processFiles(worker, task) {
+		d.scheduleRetry(task)
+		return
+	}
+	d.release(task.originalPath())
+}
+
+// Processes the file returning true if it should be retried later
+func (d *Dir) processFiles(worker uint, task *fileTask) (retry bool) {
 	startTime := time.Now()
-	fileLog := d.log.With().Uint("worker", worker).Str("filename", fileInfo.Name()).Str("dir", dir).Logger()
-	inFilePath := filepath.Join(dir, fileInfo.Name())
+	task.attempt++
+	task.worker = worker
+	task.started = startTime
+	fileLog := d.log.With().Uint("worker", worker).Str("filename", task.name).Str("dir", task.dir).Uint("attempt", task.attempt).Logger()
 
-	for _, matchGroup := range d.MatchGroups {
-		match, err := matchGroup.Match(inFilePath)
-		if err != nil {
-			fileLog.Warn().Err(err).Msg("error matching file")
-			return
+	if task.filePath == "" {
+		task.filePath = task.originalPath()
+		task.monitorFolder = d.monitorFolder()
+
+		if d.Claim {
+			claimedPath, err := d.claim(task.filePath)
+			if err != nil {
+				fileLog.Warn().Err(err).Msg("failed to claim file")
+				return false
+			}
+			fileLog.Trace().Str("claimedPath", claimedPath).Msg("claimed file")
+			task.filePath = claimedPath
+			task.monitorFolder = d.claimFolder() // keeps the relative path of the file for copiers
 		}
-		if !match {
+	}
+	inFilePath, monitorFolder := task.filePath, task.monitorFolder
+
+	succeeded := false
+	defer func() {
+		if retry {
 			return
 		}
-	}
+
+		err := d.dispose(task, succeeded)
+		if err != nil {
+			fileLog.Error().Err(err).Bool("succeeded", succeeded).TimeDiff("processingTime", time.Now(), startTime).Msg("failed to dispose of file")
+
+			if succeeded {
+				values, _ := d.taskTemplateValues(task)
+				err = d.processError(inFilePath, monitorFolder, values)
+				if err != nil {
+					fileLog.Error().Err(err).TimeDiff("processingTime", time.Now(), startTime).Msg("error while processing the error copier")
+				}
+			}
+		}
+	}()
 
 	fileStats, err := os.Stat(inFilePath)
 	if err != nil {
 		fileLog.Error().Err(err).Msg("Error getting file stats")
-
-		err = d.processError(inFilePath, d.MonitorFolder)
-		if err != nil {
-			fileLog.Error().Err(err).Msg("error occurred while processing the error copier")
-		}
-		return
+		d.fail(task, FailureStageStat, -1, err, fileLog)
+		return false
+	}
+	if task.attempt == 1 {
+		d.Stats.Inc(uint64(fileStats.Size()))
 	}
-	d.Stats.Inc(uint64(fileStats.Size()))
 	fileLog.Trace().
 		Int64("size", fileStats.Size()).
 		Str("name", fileStats.Name()).
@@ -200,70 +548,122 @@ func (d *Dir) processFiles(worker uint, fileInfo fs.DirEntry, dir string) {
 		Time("lastWriteTime", fileStats.ModTime()).
 		Msg("fileStats")
 
-	defer func() {
-		err = os.Remove(inFilePath)
+	if task.record == nil {
+		record, err := d.loadRecord(inFilePath, fileStats.Size())
 		if err != nil {
-			fileLog.Error().Err(err).TimeDiff("processingTime", time.Now(), startTime).Msg("failed to delete file")
+			fileLog.Error().Err(err).Msg("failed to load ledger record")
+			d.fail(task, FailureStageLedger, -1, err, fileLog)
+			return false
+		}
 
-			err = d.processError(inFilePath, d.MonitorFolder)
-			if err != nil {
-				fileLog.Error().Err(err).TimeDiff("processingTime", time.Now(), startTime).Msg("error while processing the error copier")
+		if record.Completed {
+			switch d.Duplicates {
+			case DuplicatePolicySkip:
+				d.Stats.Duplicates.Add(1)
+				fileLog.Info().Str("hash", record.Hash).Time("firstSeen", record.FirstSeen).Msg("skipping duplicate file")
+				succeeded = true
+				return false
+			case DuplicatePolicyFlag:
+				d.Stats.Duplicates.Add(1)
+				fileLog.Warn().Str("hash", record.Hash).Time("firstSeen", record.FirstSeen).Msg("processing duplicate file")
 			}
+			record.reset()
+		} else if record.started() {
+			fileLog.Info().Str("hash", record.Hash).Msg("resuming unfinished file")
 		}
-	}()
+		task.record = record
+	}
+	record := task.record
+	publishers := d.publishers()
+	record.resize(len(publishers), len(d.Copiers)) // publishers or copiers may be added while parked for a retry
+	defer d.saveRecord(record, fileLog)
 
-	if d.Processor != nil {
+	if d.Processor != nil && d.Processor.Executor != nil && (!record.Processed || !record.publishersDone()) {
 		fileLog.Trace().Msg("processing file")
 		results, id, err := d.Processor.Executor.Process(inFilePath)
 		if err != nil {
 			fileLog.Warn().Err(err).TimeDiff("processingTime", time.Now(), startTime).Msg("error when processing the file")
-
-			err := d.processError(inFilePath, d.MonitorFolder)
-			if err != nil {
-				fileLog.Error().Err(err).TimeDiff("processingTime", time.Now(), startTime).Msg("error while processing the error copier")
-			}
-
-			return
+			d.fail(task, FailureStageProcess, -1, err, fileLog)
+			return false
 		}
+		record.Processed = true
+		record.Ids = id
 		fileLog.Trace().Dur("processingTime", time.Since(startTime)).Msg("successfully processed file. Publishing results")
 
-		for _, publisher := range d.Publishers {
-			err = publisher.Publish(d, results, id)
-			if err != nil {
-				fileLog.Warn().Err(err).TimeDiff("processingTime", time.Now(), startTime).Msg("error while publishing the results")
-				return
+		for n, publisher := range publishers {
+			if record.Publishers[n].Done {
+				fileLog.Trace().Int("publisher", n).Msg("skipping completed publisher")
+				continue
 			}
 
-			err := d.processError(inFilePath, d.MonitorFolder)
+			err = publisher.Publish(d, results, id)
+			record.Publishers[n].set(err)
 			if err != nil {
-				fileLog.Error().Err(err).TimeDiff("processingTime", time.Now(), startTime).Msg("error while processing the error copier")
+				if d.shouldRetry(task, err) {
+					fileLog.Warn().Err(err).Int("publisher", n).TimeDiff("processingTime", time.Now(), startTime).Msg("error while publishing the results, will retry")
+					return true
+				}
+				fileLog.Warn().Err(err).TimeDiff("processingTime", time.Now(), startTime).Msg("error while publishing the results")
+				d.fail(task, FailureStagePublish, n, err, fileLog)
+				return false
 			}
+			d.saveRecord(record, fileLog)
 		}
 		fileLog.Trace().Dur("processingTime", time.Since(startTime)).Msg("successfully published results")
 	}
 
-	for _, copier := range d.Copiers {
-		err = copier.Copy(inFilePath, d.MonitorFolder)
-		if err != nil {
-			fileLog.Error().Err(err).TimeDiff("processingTime", time.Now(), startTime).Msg("error copying the file")
+	values, err := d.taskTemplateValues(task)
+	if err != nil {
+		fileLog.Error().Err(err).Msg("failed to get template values")
+		d.fail(task, FailureStageCopy, -1, err, fileLog)
+		return false
+	}
+	for n, copier := range d.Copiers {
+		if record.Copiers[n].Done {
+			fileLog.Trace().Int("copier", n).Msg("skipping completed copier")
+			continue
+		}
 
-			err := d.processError(inFilePath, d.MonitorFolder)
-			if err != nil {
-				fileLog.Error().Err(err).TimeDiff("processingTime", time.Now(), startTime).Msg("error while processing the error copier")
+		err = copyTemplated(copier, inFilePath, monitorFolder, values)
+		record.Copiers[n].set(err)
+		if err != nil {
+			if d.shouldRetry(task, err) {
+				fileLog.Warn().Err(err).Int("copier", n).TimeDiff("processingTime", time.Now(), startTime).Msg("error copying the file, will retry")
+				return true
 			}
-
-			return
+			fileLog.Error().Err(err).TimeDiff("processingTime", time.Now(), startTime).Msg("error copying the file")
+			d.fail(task, FailureStageCopy, n, err, fileLog)
+			return false
 		}
+		d.saveRecord(record, fileLog)
 	}
 
+	record.Completed = true
+	succeeded = true
 	fileLog.Trace().Dur("processingTime", time.Since(startTime)).Msg("successfully processed entire file")
+	return false
+}
+
+func (d *Dir) shouldRetry(task *fileTask, err error) bool {
+	return task.attempt < d.Retry.MaxAttempts && d.Retry.retryable(err)
+}
+
+// Records the failure for the quarantine and sends the file to the ErrorCopiers
+func (d *Dir) fail(task *fileTask, stage FailureStage, index int, err error, fileLog zerolog.Logger) {
+	task.failure = newFailure(d, task, stage, index, err)
+
+	values, _ := d.taskTemplateValues(task) // error copiers fall back to the values of the file alone
+	err = d.processError(task.filePath, task.monitorFolder, values)
+	if err != nil {
+		fileLog.Error().Err(err).TimeDiff("processingTime", time.Now(), task.started).Msg("error while processing the error copier")
+	}
 }
 
-func (d *Dir) processError(inFilePath, monitorFolder string) error {
+func (d *Dir) processError(inFilePath, monitorFolder string, values *TemplateValues) error {
 	var errs []error
 
 	for _, copier := range d.ErrorCopiers {
-		err := copier.Copy(inFilePath, monitorFolder)
+		err := copyTemplated(copier, inFilePath, monitorFolder, values)
 		if err != nil {
 			errs = append(errs, err)
 		}
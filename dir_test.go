@@ -0,0 +1,798 @@
+package fileMonitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/treavorj/zerolog"
+	"github.com/treavorj/zerolog/log"
+	"github.com/treavorj/zerolog/pkgerrors"
+)
+
+func TestStability(t *testing.T) {
+	t.Parallel()
+
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+	logger := log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339Nano}).With().Caller().Logger()
+
+	monitorFolder := t.TempDir()
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(configFile, []byte("{}"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	fileMonitor, err := NewFileMonitor(context.Background(), logger, configFile)
+	if err != nil {
+		t.Fatalf("failed to create fileMonitor: %v", err)
+	} else if fileMonitor == nil {
+		t.Fatalf("fileMonitor is nil")
+	}
+
+	destination := t.TempDir()
+	dir := &Dir{
+		Name:             t.Name(),
+		MonitorFolder:    monitorFolder,
+		MonitorFrequency: time.Millisecond * 100,
+		Stability:        Stability{Observations: 4},
+		Copiers:          []Copier{&CopierLocal{Destination: destination}},
+	}
+	err = fileMonitor.AddDir(dir)
+	if err != nil {
+		t.Fatalf("failed to add dir: %v", err)
+	}
+
+	const fileName = "growing.csv"
+	testFilepath := filepath.Join(monitorFolder, fileName)
+	err = os.WriteFile(testFilepath, []byte("partial"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	time.Sleep(time.Millisecond * 250)
+	_, err = os.Stat(testFilepath)
+	if err != nil {
+		t.Errorf("file should still be pending: %v", err)
+	}
+	if dir.Stats.Pending.Load() != 1 {
+		t.Errorf("expected 1 pending file but got %d", dir.Stats.Pending.Load())
+	}
+
+	time.Sleep(time.Millisecond * 600)
+	_, err = os.Stat(testFilepath)
+	if !os.IsNotExist(err) {
+		t.Errorf("file should not exist but does")
+	}
+	_, err = os.Stat(filepath.Join(destination, fileName))
+	if err != nil {
+		t.Errorf("file should have been copied: %v", err)
+	}
+	if dir.Stats.Pending.Load() != 0 {
+		t.Errorf("expected no pending files but got %d", dir.Stats.Pending.Load())
+	}
+}
+
+type slowExecutor struct {
+	delay time.Duration
+	calls atomic.Int32
+}
+
+func (e *slowExecutor) Process(filepath string) ([][]byte, []string, error) {
+	e.calls.Add(1)
+	time.Sleep(e.delay)
+	return nil, nil, nil
+}
+
+func TestInFlight(t *testing.T) {
+	t.Parallel()
+
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+	logger := log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339Nano}).With().Caller().Logger()
+
+	monitorFolder := t.TempDir()
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(configFile, []byte("{}"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	fileMonitor, err := NewFileMonitor(context.Background(), logger, configFile)
+	if err != nil {
+		t.Fatalf("failed to create fileMonitor: %v", err)
+	} else if fileMonitor == nil {
+		t.Fatalf("fileMonitor is nil")
+	}
+
+	executor := &slowExecutor{delay: time.Millisecond * 500}
+	dir := &Dir{
+		Name:             t.Name(),
+		MonitorFolder:    monitorFolder,
+		MonitorFrequency: time.Millisecond * 50,
+		Processor:        &Processor{Executor: executor},
+	}
+	err = fileMonitor.AddDir(dir)
+	if err != nil {
+		t.Fatalf("failed to add dir: %v", err)
+	}
+
+	testFilepath := filepath.Join(monitorFolder, "slow.csv")
+	err = os.WriteFile(testFilepath, []byte("slow"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	time.Sleep(time.Millisecond * 800)
+	_, err = os.Stat(testFilepath)
+	if !os.IsNotExist(err) {
+		t.Errorf("file should not exist but does")
+	}
+	if calls := executor.calls.Load(); calls != 1 {
+		t.Errorf("expected file to be processed once but was processed %d times", calls)
+	}
+	if dir.Stats.SkippedInFlight.Load() == 0 {
+		t.Errorf("expected skipped in flight files to be counted")
+	}
+}
+
+func TestClaimRecovery(t *testing.T) {
+	t.Parallel()
+
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+	logger := log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339Nano}).With().Caller().Logger()
+
+	monitorFolder := t.TempDir()
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(configFile, []byte("{}"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	fileMonitor, err := NewFileMonitor(context.Background(), logger, configFile)
+	if err != nil {
+		t.Fatalf("failed to create fileMonitor: %v", err)
+	} else if fileMonitor == nil {
+		t.Fatalf("fileMonitor is nil")
+	}
+
+	// left behind by a crash
+	claimedPath := filepath.Join(monitorFolder, claimFolderName, "sub", "unfinished.csv")
+	err = os.MkdirAll(filepath.Dir(claimedPath), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to create claim folder: %v", err)
+	}
+	err = os.WriteFile(claimedPath, []byte("unfinished"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	destination := t.TempDir()
+	dir := &Dir{
+		Name:             t.Name(),
+		MonitorFolder:    monitorFolder,
+		MonitorFrequency: time.Millisecond * 100,
+		KeepEmptyDirs:    true,
+		Claim:            true,
+		Recovery:         RecoveryPolicyRetry,
+		Copiers:          []Copier{&CopierLocal{Destination: destination}},
+	}
+	err = fileMonitor.AddDir(dir)
+	if err != nil {
+		t.Fatalf("failed to add dir: %v", err)
+	}
+
+	err = os.WriteFile(filepath.Join(monitorFolder, "new.csv"), []byte("new"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	time.Sleep(time.Millisecond * 600)
+	for _, name := range []string{filepath.Join("sub", "unfinished.csv"), "new.csv"} {
+		_, err = os.Stat(filepath.Join(destination, name))
+		if err != nil {
+			t.Errorf("%s should have been copied: %v", name, err)
+		}
+	}
+
+	files, err := os.ReadDir(filepath.Join(monitorFolder, claimFolderName))
+	if err != nil {
+		t.Errorf("failed to read claim folder: %v", err)
+	} else if len(files) != 0 {
+		t.Errorf("claim folder should be empty but has %d entries", len(files))
+	}
+}
+
+type countPublish struct {
+	count atomic.Int32
+}
+
+func (p *countPublish) Publish(dir *Dir, result [][]byte, id []string) error {
+	p.count.Add(1)
+	return nil
+}
+
+func TestLedgerDuplicates(t *testing.T) {
+	t.Parallel()
+
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+	logger := log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339Nano}).With().Caller().Logger()
+
+	monitorFolder := t.TempDir()
+	configFolder := t.TempDir()
+	configFile := filepath.Join(configFolder, "config.json")
+	err := os.WriteFile(configFile, []byte(`{"LedgerPath": "`+filepath.ToSlash(filepath.Join(configFolder, "ledger.db"))+`"}`), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	fileMonitor, err := NewFileMonitor(context.Background(), logger, configFile)
+	if err != nil {
+		t.Fatalf("failed to create fileMonitor: %v", err)
+	} else if fileMonitor == nil {
+		t.Fatalf("fileMonitor is nil")
+	}
+
+	publisher := &countPublish{}
+	dir := &Dir{
+		Name:             t.Name(),
+		MonitorFolder:    monitorFolder,
+		MonitorFrequency: time.Millisecond * 100,
+		Processor:        &Processor{Executor: &slowExecutor{}},
+		Publishers:       []Publisher{publisher},
+		Duplicates:       DuplicatePolicySkip,
+	}
+	err = fileMonitor.AddDir(dir)
+	if err != nil {
+		t.Fatalf("failed to add dir: %v", err)
+	}
+
+	for _, name := range []string{"first.csv", "second.csv"} {
+		// written elsewhere and moved in so the file is never seen partially written
+		tempFile := filepath.Join(configFolder, name)
+		err = os.WriteFile(tempFile, []byte("same content"), os.ModePerm)
+		if err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		err = os.Rename(tempFile, filepath.Join(monitorFolder, name))
+		if err != nil {
+			t.Fatalf("failed to move file: %v", err)
+		}
+		time.Sleep(time.Millisecond * 300)
+	}
+
+	if count := publisher.count.Load(); count != 1 {
+		t.Errorf("expected results to be published once but were published %d times", count)
+	}
+	if dir.Stats.Duplicates.Load() != 1 {
+		t.Errorf("expected 1 duplicate but got %d", dir.Stats.Duplicates.Load())
+	}
+
+	record, err := fileMonitor.ledger.Get(dir.Name, "a636bd7cd42060a4d07fa1bfbcc010eb7794c2ba721e1e3e4c20335a15b66eaf")
+	if err != nil {
+		t.Errorf("failed to get ledger record: %v", err)
+	} else if record == nil || !record.Completed {
+		t.Errorf("expected a completed ledger record: %+v", record)
+	}
+}
+
+func TestLedgerConcurrentDuplicates(t *testing.T) {
+	t.Parallel()
+
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+	logger := log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339Nano}).With().Caller().Logger()
+
+	monitorFolder := t.TempDir()
+	configFolder := t.TempDir()
+	configFile := filepath.Join(configFolder, "config.json")
+	err := os.WriteFile(configFile, []byte(`{"NumWorkers": 2, "LedgerPath": "`+filepath.ToSlash(filepath.Join(configFolder, "ledger.db"))+`"}`), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	fileMonitor, err := NewFileMonitor(context.Background(), logger, configFile)
+	if err != nil {
+		t.Fatalf("failed to create fileMonitor: %v", err)
+	} else if fileMonitor == nil {
+		t.Fatalf("fileMonitor is nil")
+	}
+
+	// the first file is parked for a retry after the first publisher so the second finds its half saved record
+	publisher := &countPublish{}
+	dir := &Dir{
+		Name:             t.Name(),
+		MonitorFolder:    monitorFolder,
+		MonitorFrequency: time.Millisecond * 50,
+		Processor:        &Processor{Executor: &slowExecutor{}},
+		Publishers:       []Publisher{publisher, &flakyPublish{failures: 1}},
+		Duplicates:       DuplicatePolicyProcess,
+		Retry:            RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond * 500},
+	}
+	err = fileMonitor.AddDir(dir)
+	if err != nil {
+		t.Fatalf("failed to add dir: %v", err)
+	}
+
+	for _, name := range []string{"first.csv", "second.csv"} {
+		tempFile := filepath.Join(configFolder, name)
+		err = os.WriteFile(tempFile, []byte("same content"), os.ModePerm)
+		if err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		err = os.Rename(tempFile, filepath.Join(monitorFolder, name))
+		if err != nil {
+			t.Fatalf("failed to move file: %v", err)
+		}
+		time.Sleep(time.Millisecond * 250)
+	}
+	time.Sleep(time.Millisecond * 1000)
+
+	if count := publisher.count.Load(); count != 2 {
+		t.Errorf("expected both files to be published but were published %d times", count)
+	}
+	for _, name := range []string{"first.csv", "second.csv"} {
+		if _, err := os.Stat(filepath.Join(monitorFolder, name)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be processed: %v", name, err)
+		}
+	}
+}
+
+type temporaryError struct{}
+
+func (e temporaryError) Error() string   { return "temporarily unavailable" }
+func (e temporaryError) Temporary() bool { return true }
+
+type flakyPublish struct {
+	failures int32
+	calls    atomic.Int32
+}
+
+func (p *flakyPublish) Publish(dir *Dir, result [][]byte, id []string) error {
+	if p.calls.Add(1) <= p.failures {
+		return temporaryError{}
+	}
+	return nil
+}
+
+func TestRetry(t *testing.T) {
+	t.Parallel()
+
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+	logger := log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339Nano}).With().Caller().Logger()
+
+	monitorFolder := t.TempDir()
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(configFile, []byte("{}"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	fileMonitor, err := NewFileMonitor(context.Background(), logger, configFile)
+	if err != nil {
+		t.Fatalf("failed to create fileMonitor: %v", err)
+	} else if fileMonitor == nil {
+		t.Fatalf("fileMonitor is nil")
+	}
+
+	publisher := &flakyPublish{failures: 2}
+	errorFolder := t.TempDir()
+	dir := &Dir{
+		Name:             t.Name(),
+		MonitorFolder:    monitorFolder,
+		MonitorFrequency: time.Millisecond * 50,
+		Processor:        &Processor{Executor: &slowExecutor{}},
+		Publishers:       []Publisher{publisher},
+		ErrorCopiers:     []Copier{&CopierLocal{Destination: errorFolder}},
+		Retry:            RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond * 100},
+	}
+	err = fileMonitor.AddDir(dir)
+	if err != nil {
+		t.Fatalf("failed to add dir: %v", err)
+	}
+
+	const fileName = "flaky.csv"
+	testFilepath := filepath.Join(monitorFolder, fileName)
+	err = os.WriteFile(testFilepath, []byte("flaky"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	time.Sleep(time.Millisecond * 150)
+	_, err = os.Stat(testFilepath)
+	if err != nil {
+		t.Errorf("file should be kept while parked: %v", err)
+	}
+	if dir.Stats.Retrying.Load() != 1 {
+		t.Errorf("expected 1 file retrying but got %d", dir.Stats.Retrying.Load())
+	}
+
+	time.Sleep(time.Millisecond * 600)
+	_, err = os.Stat(testFilepath)
+	if !os.IsNotExist(err) {
+		t.Errorf("file should not exist but does")
+	}
+	if calls := publisher.calls.Load(); calls != 3 {
+		t.Errorf("expected 3 publish attempts but got %d", calls)
+	}
+	_, err = os.Stat(filepath.Join(errorFolder, fileName))
+	if !os.IsNotExist(err) {
+		t.Errorf("file should not have been sent to the error copiers")
+	}
+}
+
+// Fails the first publish and adds a copier to the dir while the file is parked
+type growingPublish struct {
+	destination string
+	calls       atomic.Int32
+}
+
+func (p *growingPublish) Publish(dir *Dir, result [][]byte, id []string) error {
+	if p.calls.Add(1) == 1 {
+		dir.Copiers = append(dir.Copiers, &CopierLocal{Destination: p.destination})
+		return temporaryError{}
+	}
+	return nil
+}
+
+func TestRetryAddedCopier(t *testing.T) {
+	t.Parallel()
+
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+	logger := log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339Nano}).With().Caller().Logger()
+
+	monitorFolder := t.TempDir()
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(configFile, []byte("{}"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	fileMonitor, err := NewFileMonitor(context.Background(), logger, configFile)
+	if err != nil {
+		t.Fatalf("failed to create fileMonitor: %v", err)
+	} else if fileMonitor == nil {
+		t.Fatalf("fileMonitor is nil")
+	}
+
+	publisher := &growingPublish{destination: t.TempDir()}
+	dir := &Dir{
+		Name:             t.Name(),
+		MonitorFolder:    monitorFolder,
+		MonitorFrequency: time.Millisecond * 50,
+		Processor:        &Processor{Executor: &slowExecutor{}},
+		Publishers:       []Publisher{publisher},
+		Retry:            RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond * 100},
+	}
+	err = fileMonitor.AddDir(dir)
+	if err != nil {
+		t.Fatalf("failed to add dir: %v", err)
+	}
+
+	err = os.WriteFile(filepath.Join(monitorFolder, "grown.csv"), []byte("data"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	time.Sleep(time.Millisecond * 500)
+	_, err = os.Stat(filepath.Join(publisher.destination, "grown.csv"))
+	if err != nil {
+		t.Errorf("expected the copier added while parked to copy the file: %v", err)
+	}
+}
+
+type failExecutor struct {
+	calls atomic.Int32
+}
+
+func (e *failExecutor) Process(filePath string) ([][]byte, []string, error) {
+	e.calls.Add(1)
+	if strings.Contains(filepath.Base(filePath), "bad") {
+		return nil, nil, fmt.Errorf("unable to parse file")
+	}
+	return nil, nil, nil
+}
+
+func TestDisposition(t *testing.T) {
+	t.Parallel()
+
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+	logger := log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339Nano}).With().Caller().Logger()
+
+	monitorFolder := t.TempDir()
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(configFile, []byte("{}"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	fileMonitor, err := NewFileMonitor(context.Background(), logger, configFile)
+	if err != nil {
+		t.Fatalf("failed to create fileMonitor: %v", err)
+	} else if fileMonitor == nil {
+		t.Fatalf("fileMonitor is nil")
+	}
+
+	executor := &failExecutor{}
+	archiveFolder := filepath.Join(monitorFolder, "archive") // must not be processed again
+	dir := &Dir{
+		Name:             t.Name(),
+		MonitorFolder:    monitorFolder,
+		MonitorFrequency: time.Millisecond * 50,
+		Claim:            true,
+		Processor:        &Processor{Executor: executor},
+		OnSuccess:        Disposition{Action: DispositionArchive, ArchiveFolder: archiveFolder, DateLayout: "2006/01/02"},
+		OnFailure:        Disposition{Action: DispositionLeave},
+	}
+	err = fileMonitor.AddDir(dir)
+	if err != nil {
+		t.Fatalf("failed to add dir: %v", err)
+	}
+
+	for _, name := range []string{"good.csv", "bad.csv"} {
+		err = os.WriteFile(filepath.Join(monitorFolder, name), []byte(name), os.ModePerm)
+		if err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	time.Sleep(time.Millisecond * 500)
+	_, err = os.Stat(filepath.Join(archiveFolder, time.Now().Format("2006"), time.Now().Format("01"), time.Now().Format("02"), "good.csv"))
+	if err != nil {
+		t.Errorf("good.csv should have been archived: %v", err)
+	}
+	_, err = os.Stat(filepath.Join(monitorFolder, "good.csv"))
+	if !os.IsNotExist(err) {
+		t.Errorf("good.csv should not exist but does")
+	}
+	_, err = os.Stat(filepath.Join(monitorFolder, "bad.csv"))
+	if err != nil {
+		t.Errorf("bad.csv should have been left in place: %v", err)
+	}
+	if calls := executor.calls.Load(); calls != 2 {
+		t.Errorf("expected each file to be processed once but got %d calls", calls)
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	t.Parallel()
+
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+	logger := log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339Nano}).With().Caller().Logger()
+
+	monitorFolder := t.TempDir()
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(configFile, []byte("{}"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	fileMonitor, err := NewFileMonitor(context.Background(), logger, configFile)
+	if err != nil {
+		t.Fatalf("failed to create fileMonitor: %v", err)
+	} else if fileMonitor == nil {
+		t.Fatalf("fileMonitor is nil")
+	}
+
+	executor := &failExecutor{}
+	quarantineFolder := filepath.Join(monitorFolder, "quarantine") // must not be processed again
+	dir := &Dir{
+		Name:             t.Name(),
+		MonitorFolder:    monitorFolder,
+		MonitorFrequency: time.Millisecond * 50,
+		Claim:            true,
+		Processor:        &Processor{Executor: executor},
+		QuarantineFolder: quarantineFolder,
+	}
+	err = fileMonitor.AddDir(dir)
+	if err != nil {
+		t.Fatalf("failed to add dir: %v", err)
+	}
+
+	err = os.MkdirAll(filepath.Join(monitorFolder, "sub"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to create sub folder: %v", err)
+	}
+	err = os.WriteFile(filepath.Join(monitorFolder, "sub", "bad.csv"), []byte("bad"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	time.Sleep(time.Millisecond * 500)
+	_, err = os.Stat(filepath.Join(quarantineFolder, "sub", "bad.csv"))
+	if err != nil {
+		t.Fatalf("bad.csv should have been quarantined: %v", err)
+	}
+	if calls := executor.calls.Load(); calls != 1 {
+		t.Errorf("expected the quarantined file to be processed once but got %d calls", calls)
+	}
+
+	failures, err := dir.Quarantined()
+	if err != nil {
+		t.Fatalf("failed to list quarantined files: %v", err)
+	} else if len(failures) != 1 {
+		t.Fatalf("expected 1 quarantined file but got %d", len(failures))
+	}
+	failure := failures[0]
+	if failure.Stage != FailureStageProcess {
+		t.Errorf("expected stage %s but got %s", FailureStageProcess, failure.Stage)
+	}
+	if failure.File != filepath.Join(monitorFolder, "sub", "bad.csv") {
+		t.Errorf("unexpected file in failure: %s", failure.File)
+	}
+	if failure.Attempt != 1 || len(failure.Errors) == 0 {
+		t.Errorf("unexpected failure: %+v", failure)
+	}
+
+	outside := filepath.Join(t.TempDir(), "outside.csv")
+	err = os.WriteFile(outside, []byte("outside"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	relOutside, err := filepath.Rel(quarantineFolder, outside)
+	if err != nil {
+		t.Fatalf("failed to get relative path: %v", err)
+	}
+	for _, relPath := range []string{relOutside, outside} {
+		if requeued, err := fileMonitor.Requeue(dir.Name, relPath); err == nil || requeued != 0 {
+			t.Errorf("expected %s outside the quarantine folder to be rejected but requeued %d: %v", relPath, requeued, err)
+		}
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("file outside the quarantine folder should not have been moved: %v", err)
+	}
+
+	requeued, err := fileMonitor.Requeue(dir.Name)
+	if err != nil {
+		t.Fatalf("failed to requeue: %v", err)
+	} else if requeued != 1 {
+		t.Errorf("expected 1 file to be requeued but got %d", requeued)
+	}
+
+	time.Sleep(time.Millisecond * 500)
+	if calls := executor.calls.Load(); calls != 2 {
+		t.Errorf("expected the requeued file to be processed again but got %d calls", calls)
+	}
+	failures, err = dir.Quarantined()
+	if err != nil {
+		t.Fatalf("failed to list quarantined files: %v", err)
+	} else if len(failures) != 1 || failures[0].Attempt != 1 {
+		t.Errorf("expected the requeued file to be quarantined again: %+v", failures)
+	}
+}
+
+func TestPause(t *testing.T) {
+	t.Parallel()
+
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+	logger := log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339Nano}).With().Caller().Logger()
+
+	monitorFolder := t.TempDir()
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(configFile, []byte("{}"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	fileMonitor, err := NewFileMonitor(context.Background(), logger, configFile)
+	if err != nil {
+		t.Fatalf("failed to create fileMonitor: %v", err)
+	} else if fileMonitor == nil {
+		t.Fatalf("fileMonitor is nil")
+	}
+
+	executor := &slowExecutor{}
+	dir := &Dir{
+		Name:             t.Name(),
+		MonitorFolder:    monitorFolder,
+		MonitorFrequency: time.Millisecond * 50,
+		WatcherType:      WatcherTypeNotify,
+		Processor:        &Processor{Executor: executor},
+	}
+	err = fileMonitor.AddDir(dir)
+	if err != nil {
+		t.Fatalf("failed to add dir: %v", err)
+	}
+
+	err = fileMonitor.Pause(dir.Name)
+	if err != nil {
+		t.Fatalf("failed to pause dir: %v", err)
+	}
+	filePath := filepath.Join(monitorFolder, "paused.csv")
+	err = os.WriteFile(filePath, []byte("data"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	time.Sleep(time.Millisecond * 300)
+	if calls := executor.calls.Load(); calls != 0 {
+		t.Errorf("expected no files to be processed while paused but got %d", calls)
+	}
+	if !dir.IsActive() {
+		t.Errorf("expected paused dir to still be active")
+	}
+
+	reloaded, err := NewFileMonitor(context.Background(), logger, configFile)
+	if err != nil {
+		t.Fatalf("failed to reload fileMonitor: %v", err)
+	} else if !reloaded.Dirs[dir.Name].Paused {
+		t.Errorf("expected paused state to be persisted")
+	}
+	_, err = reloaded.Stop(context.Background())
+	if err != nil {
+		t.Errorf("failed to stop reloaded fileMonitor: %v", err)
+	}
+
+	err = fileMonitor.Resume(dir.Name)
+	if err != nil {
+		t.Fatalf("failed to resume dir: %v", err)
+	}
+	time.Sleep(time.Millisecond * 300)
+	if calls := executor.calls.Load(); calls != 1 {
+		t.Errorf("expected the file to be processed once resumed but got %d calls", calls)
+	}
+	_, err = os.Stat(filePath)
+	if !os.IsNotExist(err) {
+		t.Errorf("expected file to be removed once resumed")
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return false }
+
+func TestRetryable(t *testing.T) {
+	t.Parallel()
+
+	policy := RetryPolicy{RetryableErrors: []string{`quota`}}
+	err := policy.compile()
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{temporaryError{}, true},
+		{&net.OpError{Op: "read", Err: timeoutError{}}, true},
+		{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, true},
+		{&net.OpError{Op: "read", Err: errors.New("authentication failed")}, false},
+		{&textproto.Error{Code: 421, Msg: "too many connections"}, true},
+		{&textproto.Error{Code: 530, Msg: "login incorrect"}, false},
+		{errors.New("storage quota exceeded"), true},
+		{errors.New("no such file"), false},
+	}
+	for _, test := range tests {
+		if retryable := policy.retryable(test.err); retryable != test.retryable {
+			t.Errorf("%v: expected retryable %v but got %v", test.err, test.retryable, retryable)
+		}
+	}
+
+	policy = RetryPolicy{RetryableErrors: []string{`(`}}
+	if policy.compile() == nil {
+		t.Errorf("expected an invalid expression to fail")
+	}
+	dir := &Dir{Name: t.Name(), MonitorFolder: t.TempDir(), MonitorFrequency: time.Second, Retry: policy, parent: &FileMonitor{}}
+	if dir.Monitor() == nil {
+		t.Errorf("expected the dir not to start with an invalid expression")
+	}
+}
@@ -0,0 +1,318 @@
+package fileMonitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+type DispositionAction int
+
+const (
+	DispositionDelete  DispositionAction = iota // Deletes the file
+	DispositionArchive                          // Moves the file into ArchiveFolder keeping its path relative to the MonitorFolder
+	DispositionRename                           // Renames the file in place by appending Suffix. Files with the suffix are not processed
+	DispositionLeave                            // Leaves the file in place and remembers it so it is not processed again until it changes
+)
+
+const defaultDispositionSuffix = ".done"
+
+// What happens to the source file once it has been processed
+type Disposition struct {
+	Action        DispositionAction
+	ArchiveFolder string // Destination for DispositionArchive
+	DateLayout    string // Optional time layout of subfolders within ArchiveFolder using the processing time, e.g. "2006/01/02"
+	Suffix        string // Appended to the name for DispositionRename. Defaults to ".done"
+}
+
+func (p *Disposition) suffix() string {
+	if p.Suffix == "" {
+		return defaultDispositionSuffix
+	}
+	return p.Suffix
+}
+
+// Checks if path is the ArchiveFolder of a disposition so archived files within the MonitorFolder are not processed again
+//
+// Archives of remote dirs are on the source and are skipped by isRemoteArchived instead
+func (d *Dir) isArchiveFolder(path string) bool {
+	if d.isRemote() {
+		return false
+	}
+	for _, disposition := range []*Disposition{&d.OnSuccess, &d.OnFailure} {
+		if disposition.Action == DispositionArchive && disposition.ArchiveFolder != "" &&
+			filepath.Clean(path) == filepath.Clean(expandEnv(disposition.ArchiveFolder)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Applies the disposition to the file at filePath whose original location was originalPath
+func (p *Disposition) apply(d *Dir, filePath, monitorFolder, originalPath string) error {
+	switch p.Action {
+	case DispositionDelete:
+		return os.Remove(filePath)
+	case DispositionArchive:
+		if p.ArchiveFolder == "" {
+			return fmt.Errorf("no archive folder provided")
+		}
+		destination := expandEnv(p.ArchiveFolder)
+		if p.DateLayout != "" {
+			destination = filepath.Join(destination, filepath.FromSlash(time.Now().Format(p.DateLayout)))
+		}
+		return moveFile(filePath, monitorFolder, destination)
+	case DispositionRename:
+		return os.Rename(filePath, originalPath+p.suffix())
+	case DispositionLeave:
+		if filePath != originalPath {
+			err := os.Rename(filePath, originalPath)
+			if err != nil {
+				return fmt.Errorf("unable to move file back: %w", err)
+			}
+		}
+		return d.rememberLeft(originalPath)
+	default:
+		return fmt.Errorf("invalid disposition action: %d", p.Action)
+	}
+}
+
+// Applies the disposition to the file at remotePath within the source of a remote dir
+func (p *Disposition) applyRemote(d *Dir, remotePath string) error {
+	switch p.Action {
+	case DispositionDelete:
+		return d.withSource(func(source Source) error {
+			return source.Remove(remotePath)
+		})
+	case DispositionArchive:
+		if p.ArchiveFolder == "" {
+			return fmt.Errorf("no archive folder provided")
+		}
+		destination := strings.TrimPrefix(filepath.ToSlash(expandEnv(p.ArchiveFolder)), "/")
+		if p.DateLayout != "" {
+			destination = path.Join(destination, time.Now().Format(p.DateLayout))
+		}
+		return d.withSource(func(source Source) error {
+			return source.Rename(remotePath, path.Join(destination, remotePath))
+		})
+	case DispositionRename:
+		return d.withSource(func(source Source) error {
+			return source.Rename(remotePath, remotePath+p.suffix())
+		})
+	case DispositionLeave:
+		return nil
+	default:
+		return fmt.Errorf("invalid disposition action: %d", p.Action)
+	}
+}
+
+// Moves the file into destination keeping its path relative to monitorFolder
+func moveFile(filePath, monitorFolder, destination string) error {
+	outFileName := getOutFileName(filePath, monitorFolder, destination)
+	err := os.MkdirAll(filepath.Dir(outFileName), os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("unable to create directory: %w", err)
+	}
+
+	err = os.Rename(filePath, outFileName)
+	if err == nil {
+		return nil
+	}
+
+	// likely on a different device so copy instead
+	err = (&CopierLocal{Destination: destination}).Copy(filePath, monitorFolder)
+	if err != nil {
+		return err
+	}
+	return os.Remove(filePath)
+}
+
+// Disposes of the file according to OnSuccess or OnFailure, or quarantines it if configured
+func (d *Dir) dispose(task *fileTask, succeeded bool) error {
+	if d.isRemote() {
+		return d.disposeRemote(task, succeeded)
+	}
+
+	var err error
+	switch {
+	case succeeded:
+		err = d.OnSuccess.apply(d, task.filePath, task.monitorFolder, task.originalPath())
+	case d.quarantineFolder() != "" && task.failure != nil:
+		err = d.quarantine(task.filePath, task.monitorFolder, task.failure)
+	default:
+		err = d.OnFailure.apply(d, task.filePath, task.monitorFolder, task.originalPath())
+	}
+	if err != nil {
+		return err
+	}
+	if d.Claim {
+		d.cleanClaimFolder(task.filePath)
+	}
+	return nil
+}
+
+// Disposes of the file on the source and removes the staged copy
+//
+// The staged copy is kept if the source fails so the file is not downloaded again
+func (d *Dir) disposeRemote(task *fileTask, succeeded bool) error {
+	remotePath, err := d.remotePath(task.originalPath())
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case succeeded:
+		err = d.disposeStaged(task, &d.OnSuccess, remotePath)
+	case d.quarantineFolder() != "" && task.failure != nil:
+		err = d.quarantine(task.filePath, task.monitorFolder, task.failure)
+		if err == nil {
+			err = d.withSource(func(source Source) error {
+				return source.Remove(remotePath)
+			})
+		}
+	default:
+		err = d.disposeStaged(task, &d.OnFailure, remotePath)
+	}
+	if err != nil {
+		return err
+	}
+	if d.Claim {
+		d.cleanClaimFolder(task.filePath)
+	}
+	return nil
+}
+
+func (d *Dir) disposeStaged(task *fileTask, disposition *Disposition, remotePath string) error {
+	if disposition.Action == DispositionLeave {
+		fileStats, err := os.Stat(task.filePath)
+		if err != nil {
+			return err
+		}
+		// the staged copy has the size and modification time of the file on the source
+		err = d.rememberLeftFile(task.originalPath(), leftFile{Size: fileStats.Size(), ModTime: fileStats.ModTime()})
+		if err != nil {
+			return err
+		}
+	}
+
+	err := disposition.applyRemote(d, remotePath)
+	if err != nil {
+		return fmt.Errorf("unable to dispose of file on source: %w", err)
+	}
+	return os.Remove(task.filePath)
+}
+
+// Checks if the file name has a suffix added by DispositionRename
+func (d *Dir) isRenamed(name string) bool {
+	for _, disposition := range []*Disposition{&d.OnSuccess, &d.OnFailure} {
+		if disposition.Action == DispositionRename && strings.HasSuffix(name, disposition.suffix()) {
+			return true
+		}
+	}
+	return false
+}
+
+// File left in place by DispositionLeave
+type leftFile struct {
+	Size    int64
+	ModTime time.Time
+}
+
+func leftBucket(dirName string) []byte {
+	return []byte(dirName + "/left")
+}
+
+// Remembers the file so it is skipped until it changes. Stored in the ledger if one is configured
+func (d *Dir) rememberLeft(filePath string) error {
+	fileStats, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	return d.rememberLeftFile(filePath, leftFile{Size: fileStats.Size(), ModTime: fileStats.ModTime()})
+}
+
+func (d *Dir) rememberLeftFile(filePath string, left leftFile) error {
+	if d.parent.ledger != nil {
+		return d.parent.ledger.putLeft(d.Name, filePath, left)
+	}
+
+	d.leftLock.Lock()
+	defer d.leftLock.Unlock()
+	if d.left == nil {
+		d.left = make(map[string]leftFile)
+	}
+	d.left[filePath] = left
+	return nil
+}
+
+// Checks if the file was left in place and has not changed since
+func (d *Dir) wasLeft(filePath string) bool {
+	if d.OnSuccess.Action != DispositionLeave && d.OnFailure.Action != DispositionLeave {
+		return false
+	}
+
+	fileStats, err := os.Stat(filePath)
+	if err != nil {
+		return false
+	}
+	return d.isLeft(filePath, fileStats.Size(), fileStats.ModTime())
+}
+
+// Checks if the file was left in place with the size and modification time
+func (d *Dir) isLeft(filePath string, size int64, modTime time.Time) bool {
+	if d.OnSuccess.Action != DispositionLeave && d.OnFailure.Action != DispositionLeave {
+		return false
+	}
+
+	var left leftFile
+	var ok bool
+	if d.parent.ledger != nil {
+		var err error
+		left, ok, err = d.parent.ledger.getLeft(d.Name, filePath)
+		if err != nil {
+			d.log.Warn().Err(err).Str("filePath", filePath).Msg("unable to check ledger for file left in place")
+		}
+	} else {
+		d.leftLock.Lock()
+		left, ok = d.left[filePath]
+		d.leftLock.Unlock()
+	}
+	return ok && size == left.Size && modTime.Equal(left.ModTime)
+}
+
+func (l *Ledger) putLeft(dirName, filePath string, left leftFile) error {
+	data, err := json.Marshal(left)
+	if err != nil {
+		return fmt.Errorf("unable to marshal left file: %w", err)
+	}
+
+	return l.db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(leftBucket(dirName))
+		if err != nil {
+			return fmt.Errorf("unable to create bucket: %w", err)
+		}
+		return bucket.Put([]byte(filePath), data)
+	})
+}
+
+func (l *Ledger) getLeft(dirName, filePath string) (left leftFile, ok bool, err error) {
+	err = l.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(leftBucket(dirName))
+		if bucket == nil {
+			return nil
+		}
+		data := bucket.Get([]byte(filePath))
+		if data == nil {
+			return nil
+		}
+		ok = true
+		return json.Unmarshal(data, &left)
+	})
+	return left, ok, err
+}
@@ -0,0 +1,707 @@
+package fileMonitor
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+)
+
+type FtpTls int
+
+const (
+	FtpTlsNone     FtpTls = iota // Plain text
+	FtpTlsExplicit               // Upgrades the connection with AUTH TLS, usually on port 21
+	FtpTlsImplicit               // Connects with TLS, usually on port 990
+)
+
+const (
+	defaultFtpMaxConnections = 4
+	defaultFtpKeepAlive      = time.Second * 30
+	defaultFtpIdleTimeout    = time.Minute * 5
+)
+
+// Connection settings shared by the FTP copier and source
+type ftpConfig struct {
+	server      string
+	username    string
+	password    string
+	tls         FtpTls
+	caFile      string
+	certFile    string
+	keyFile     string
+	disableEpsv bool
+	timeout     time.Duration
+}
+
+// Builds the TLS config verifying the server against the CA file or the system pool
+func (c *ftpConfig) tlsConfig(host string) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         host,
+		ClientSessionCache: tls.NewLRUClientSessionCache(0), // servers may require data connections to resume the session
+	}
+
+	if c.caFile != "" {
+		pem, err := os.ReadFile(expandHome(c.caFile))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA file: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file: %s", c.caFile)
+		}
+	}
+
+	if c.certFile != "" || c.keyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(expandHome(c.certFile), expandHome(c.keyFile))
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
+
+// Connects and logs in. The server defaults to port 21 or 990 with FtpTlsImplicit
+func (c *ftpConfig) dial() (*ftp.ServerConn, error) {
+	server := c.server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		port := "21"
+		if c.tls == FtpTlsImplicit {
+			port = "990"
+		}
+		server = net.JoinHostPort(server, port)
+	}
+
+	timeout := c.timeout
+	if timeout <= 0 {
+		timeout = validateTimeout
+	}
+	options := []ftp.DialOption{
+		ftp.DialWithTimeout(timeout),
+		ftp.DialWithShutTimeout(timeout),
+		ftp.DialWithDisabledEPSV(c.disableEpsv),
+	}
+
+	switch c.tls {
+	case FtpTlsNone:
+	case FtpTlsExplicit, FtpTlsImplicit:
+		host, _, _ := net.SplitHostPort(server)
+		tlsConfig, err := c.tlsConfig(host)
+		if err != nil {
+			return nil, err
+		}
+		if c.tls == FtpTlsExplicit {
+			options = append(options, ftp.DialWithExplicitTLS(tlsConfig))
+		} else {
+			options = append(options, ftp.DialWithTLS(tlsConfig))
+		}
+	default:
+		return nil, fmt.Errorf("invalid FTP TLS mode: %d", c.tls)
+	}
+
+	conn, err := ftp.Dial(server, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to FTP server: %w", err)
+	}
+	err = conn.Login(c.username, c.password)
+	if err != nil {
+		conn.Quit()
+		return nil, fmt.Errorf("failed to log in to FTP server: %w", err)
+	}
+	return conn, nil
+}
+
+// Connection waiting in a pool
+type ftpIdleConn struct {
+	conn  *ftp.ServerConn
+	since time.Time
+}
+
+// Connections to a server shared by the workers using a copier
+//
+// Idle connections are kept alive with NOOPs and closed after idleTimeout. A connection is only used by one worker
+// at a time as the FTP protocol does not allow concurrent commands
+type ftpPool struct {
+	dial           func() (*ftp.ServerConn, error)
+	maxConnections int
+	keepAlive      time.Duration
+	idleTimeout    time.Duration
+
+	lock         sync.Mutex
+	released     *sync.Cond // signaled when a connection becomes idle or is closed
+	open         int
+	idle         []*ftpIdleConn // most recently used last
+	keepingAlive bool
+	stopped      bool // set by close. Connections still in use are quit once returned
+}
+
+func newFtpPool(dial func() (*ftp.ServerConn, error), maxConnections int, keepAlive, idleTimeout time.Duration) *ftpPool {
+	if maxConnections <= 0 {
+		maxConnections = defaultFtpMaxConnections
+	}
+	if keepAlive <= 0 {
+		keepAlive = defaultFtpKeepAlive
+	}
+	if idleTimeout <= 0 {
+		idleTimeout = defaultFtpIdleTimeout
+	}
+	p := &ftpPool{
+		dial:           dial,
+		maxConnections: maxConnections,
+		keepAlive:      keepAlive,
+		idleTimeout:    idleTimeout,
+	}
+	p.released = sync.NewCond(&p.lock)
+	return p
+}
+
+// Runs fn with a connection from the pool
+//
+// If fn fails on a connection which no longer responds it is closed. Failures on reused connections are retried on
+// another connection as the server may have dropped it while idle
+func (p *ftpPool) do(fn func(conn *ftp.ServerConn) error) error {
+	for {
+		conn, reused, err := p.get()
+		if err != nil {
+			return err
+		}
+
+		err = fn(conn)
+		if err == nil {
+			p.put(conn)
+			return nil
+		} else if conn.NoOp() == nil {
+			p.put(conn)
+			return err
+		}
+
+		p.discard(conn)
+		if !reused {
+			return err
+		}
+	}
+}
+
+// Takes an idle connection or dials a new one, waiting while maxConnections are in use
+func (p *ftpPool) get() (conn *ftp.ServerConn, reused bool, err error) {
+	p.lock.Lock()
+	for len(p.idle) == 0 && p.open >= p.maxConnections {
+		p.released.Wait()
+	}
+	if len(p.idle) > 0 {
+		idle := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.lock.Unlock()
+		return idle.conn, true, nil
+	}
+	p.open++
+	p.lock.Unlock()
+
+	conn, err = p.dial()
+	if err != nil {
+		p.closed()
+		return nil, false, err
+	}
+	return conn, false, nil
+}
+
+func (p *ftpPool) put(conn *ftp.ServerConn) {
+	p.lock.Lock()
+	if p.stopped {
+		p.lock.Unlock()
+		p.discard(conn)
+		return
+	}
+	defer p.lock.Unlock()
+
+	p.idle = append(p.idle, &ftpIdleConn{conn: conn, since: time.Now()})
+	p.released.Signal()
+	if !p.keepingAlive {
+		p.keepingAlive = true
+		go p.keepIdleAlive()
+	}
+}
+
+func (p *ftpPool) discard(conn *ftp.ServerConn) {
+	_ = conn.Quit()
+	p.closed()
+}
+
+func (p *ftpPool) closed() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.open--
+	p.released.Signal()
+}
+
+// Quits the idle connections. Workers still copying finish on their connection which is quit once returned
+func (p *ftpPool) close() {
+	p.lock.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.stopped = true
+	p.lock.Unlock()
+
+	for _, idleConn := range idle {
+		p.discard(idleConn.conn)
+	}
+}
+
+// Sends NOOPs on idle connections every keepAlive and closes those which fail or exceed idleTimeout
+//
+// Stops once no connections are idle so unused pools do not leak
+func (p *ftpPool) keepIdleAlive() {
+	ticker := time.NewTicker(p.keepAlive)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		// take the idle connections so workers do not use them during the NOOP
+		p.lock.Lock()
+		idle := p.idle
+		p.idle = nil
+		p.lock.Unlock()
+
+		alive := make([]*ftpIdleConn, 0, len(idle))
+		for _, idleConn := range idle {
+			if time.Since(idleConn.since) > p.idleTimeout || idleConn.conn.NoOp() != nil {
+				p.discard(idleConn.conn)
+				continue
+			}
+			alive = append(alive, idleConn)
+		}
+
+		p.lock.Lock()
+		if p.stopped {
+			p.lock.Unlock()
+			for _, idleConn := range alive {
+				p.discard(idleConn.conn)
+			}
+			p.lock.Lock()
+			alive = nil
+		}
+		p.idle = append(alive, p.idle...)
+		p.released.Broadcast()
+		if len(p.idle) == 0 {
+			p.keepingAlive = false
+			p.lock.Unlock()
+			return
+		}
+		p.lock.Unlock()
+	}
+}
+
+// Creates the folder and any missing parents. Errors are only returned if the folder can not be entered afterwards
+// as servers report existing folders differently
+func ftpMakeDirAll(conn *ftp.ServerConn, dir string) error {
+	dir = path.Clean(dir)
+	if dir == "." || dir == "/" {
+		return nil
+	}
+
+	current, err := conn.CurrentDir()
+	if err != nil {
+		return fmt.Errorf("unable to get current directory: %w", err)
+	}
+	defer conn.ChangeDir(current)
+
+	if conn.ChangeDir(dir) == nil {
+		return nil
+	}
+	err = ftpMakeDirAll(conn, path.Dir(dir))
+	if err != nil {
+		return err
+	}
+	_ = conn.MakeDir(dir)
+	err = conn.ChangeDir(dir)
+	if err != nil {
+		return fmt.Errorf("unable to create directory %s: %w", dir, err)
+	}
+	return nil
+}
+
+// Copies files to an FTP server over plain text or TLS
+//
+// Files are uploaded under a temporary name, checked with the SIZE command and renamed once complete so readers
+// never see partial files. Missing folders are created. Connections are pooled and shared by all workers using the copier
+// until it is closed. Only passive data connections are supported
+type CopierFtp struct {
+	Server         string // Host with an optional port. Defaults to port 21 or 990 with FtpTlsImplicit
+	Username       string
+	Password       string `json:"-"` // Only set in code and never stored. Use PasswordSecret in the config
+	PasswordSecret string // Reference to the password in a SecretProvider such as "env:FTP_PASSWORD"
+	Destination    string
+
+	Tls            FtpTls
+	CaFile         string        // PEM certificates trusted to sign the server certificate. Defaults to the system pool
+	CertFile       string        // PEM client certificate for servers requiring one
+	KeyFile        string        // PEM key of CertFile
+	DisableEpsv    bool          // Only uses PASV for servers or firewalls which mishandle EPSV
+	Timeout        time.Duration // Connecting and waiting for transfers to finish. Defaults to 10s
+	MaxConnections int           // Open connections shared by the workers. Defaults to 4
+	KeepAlive      time.Duration // Interval of NOOPs sent on idle connections. Defaults to 30s
+	IdleTimeout    time.Duration // Idle connections are closed after this. Defaults to 5m
+	OnConflict     ConflictPolicy
+	PathTemplate   PathTemplate
+
+	secrets        *Secrets
+	legacyPassword bool // Password was read from a config saved before secret providers
+
+	poolLock sync.Mutex
+	pool     *ftpPool
+}
+
+func (c *CopierFtp) UseSecrets(secrets *Secrets) {
+	c.secrets = secrets
+}
+
+// Resolves the password from PasswordSecret if provided
+func (c *CopierFtp) password() (string, error) {
+	if c.PasswordSecret == "" {
+		return c.Password, nil
+	}
+	return c.secrets.Resolve(c.PasswordSecret)
+}
+
+// Connects with a new connection outside of the pool
+func (c *CopierFtp) dial() (*ftp.ServerConn, error) {
+	password, err := c.password()
+	if err != nil {
+		return nil, err
+	}
+
+	config := &ftpConfig{
+		server:      expandEnv(c.Server),
+		username:    expandEnv(c.Username),
+		password:    password,
+		tls:         c.Tls,
+		caFile:      expandEnv(c.CaFile),
+		certFile:    expandEnv(c.CertFile),
+		keyFile:     expandEnv(c.KeyFile),
+		disableEpsv: c.DisableEpsv,
+		timeout:     c.Timeout,
+	}
+	return config.dial()
+}
+
+func (c *CopierFtp) getPool() *ftpPool {
+	c.poolLock.Lock()
+	defer c.poolLock.Unlock()
+	if c.pool == nil {
+		c.pool = newFtpPool(c.dial, c.MaxConnections, c.KeepAlive, c.IdleTimeout)
+	}
+	return c.pool
+}
+
+// Quits the pooled connections. A later copy opens a new pool
+func (c *CopierFtp) Close() error {
+	c.poolLock.Lock()
+	pool := c.pool
+	c.pool = nil
+	c.poolLock.Unlock()
+
+	if pool != nil {
+		pool.close()
+	}
+	return nil
+}
+
+func (c *CopierFtp) Copy(inFilePath, monitorDir string) error {
+	return c.CopyTemplated(inFilePath, monitorDir, nil)
+}
+
+func (c *CopierFtp) CopyTemplated(inFilePath, monitorDir string, values *TemplateValues) error {
+	outFileName, err := c.PathTemplate.outFileName(inFilePath, monitorDir, expandEnv(c.Destination), values)
+	if err != nil {
+		return err
+	}
+	outFileName = filepath.ToSlash(outFileName)
+	return c.getPool().do(func(conn *ftp.ServerConn) error {
+		inFile, err := os.Open(inFilePath)
+		if err != nil {
+			return fmt.Errorf("failed to open the file: %w", err)
+		}
+		defer inFile.Close()
+		fileStats, err := inFile.Stat()
+		if err != nil {
+			return fmt.Errorf("failed to get file stats: %w", err)
+		}
+
+		err = ftpMakeDirAll(conn, path.Dir(outFileName))
+		if err != nil {
+			return err
+		}
+		outFileName, skip, err := c.OnConflict.resolve(&ftpConflictTarget{conn}, outFileName, inFilePath)
+		if err != nil || skip {
+			return err
+		}
+
+		tempName := outFileName + ".part"
+		err = conn.Stor(tempName, inFile)
+		if err != nil {
+			_ = conn.Delete(tempName)
+			return fmt.Errorf("failed to upload file to FTP server: %w", err)
+		}
+		err = ftpVerifySize(conn, tempName, fileStats.Size())
+		if err != nil {
+			_ = conn.Delete(tempName)
+			return err
+		}
+
+		err = conn.Rename(tempName, outFileName)
+		if err != nil {
+			// server does not replace existing files
+			_ = conn.Delete(outFileName)
+			err = conn.Rename(tempName, outFileName)
+		}
+		if err != nil {
+			_ = conn.Delete(tempName)
+			return fmt.Errorf("unable to rename file into place: %w", err)
+		}
+		return nil
+	})
+}
+
+type ftpConflictTarget struct {
+	conn *ftp.ServerConn
+}
+
+// Uses SIZE and falls back to listing the folder for servers which do not support it
+func (t *ftpConflictTarget) exists(name string) (bool, error) {
+	_, err := t.conn.FileSize(name)
+	var protocolErr *textproto.Error
+	if err == nil {
+		return true, nil
+	} else if !errors.As(err, &protocolErr) {
+		return false, err
+	} else if protocolErr.Code == ftp.StatusFileUnavailable {
+		return false, nil
+	}
+
+	names, err := t.conn.NameList(path.Dir(name))
+	if err != nil {
+		return false, err
+	}
+	for _, listed := range names {
+		if path.Base(listed) == path.Base(name) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (t *ftpConflictTarget) open(name string) (io.ReadCloser, error) {
+	return t.conn.Retr(name)
+}
+
+// Checks the uploaded file has the expected size using the SIZE command. Skipped if the server does not support it
+func ftpVerifySize(conn *ftp.ServerConn, filePath string, expected int64) error {
+	size, err := conn.FileSize(filePath)
+	var protocolErr *textproto.Error
+	if errors.As(err, &protocolErr) && (protocolErr.Code == ftp.StatusBadCommand || protocolErr.Code == ftp.StatusNotImplemented) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("unable to get size of uploaded file: %w", err)
+	} else if size != expected {
+		return fmt.Errorf("uploaded %d bytes but expected %d", size, expected)
+	}
+	return nil
+}
+
+// Checks the settings without connecting to the server
+func (c *CopierFtp) ValidateConfig() error {
+	if c.Server == "" {
+		return fmt.Errorf("server must not be empty")
+	}
+	err := c.OnConflict.validate()
+	if err != nil {
+		return err
+	}
+	return c.PathTemplate.validate()
+}
+
+// Checks the server is reachable and the credentials are accepted
+func (c *CopierFtp) Validate() error {
+	err := c.ValidateConfig()
+	if err != nil {
+		return err
+	}
+
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	return conn.Quit()
+}
+
+func (c *CopierFtp) GetType() CopierType {
+	return CopierTypeFTP
+}
+
+func (c *CopierFtp) MarshalJSON() ([]byte, error) {
+	type Alias CopierFtp
+	return json.Marshal(&struct {
+		Type CopierType `json:"Type"`
+		*Alias
+		Timeout     Duration
+		KeepAlive   Duration
+		IdleTimeout Duration
+	}{
+		Type:        c.GetType(),
+		Alias:       (*Alias)(c),
+		Timeout:     Duration(c.Timeout),
+		KeepAlive:   Duration(c.KeepAlive),
+		IdleTimeout: Duration(c.IdleTimeout),
+	})
+}
+
+func (c *CopierFtp) UnmarshalJSON(data []byte) error {
+	type Alias CopierFtp
+	aux := &struct {
+		*Alias
+		Password    string // plaintext passwords of older configs are kept in memory but never saved again
+		Timeout     Duration
+		KeepAlive   Duration
+		IdleTimeout Duration
+	}{
+		Alias:       (*Alias)(c),
+		Timeout:     Duration(c.Timeout),
+		KeepAlive:   Duration(c.KeepAlive),
+		IdleTimeout: Duration(c.IdleTimeout),
+	}
+	err := json.Unmarshal(data, aux)
+	if err != nil {
+		return err
+	}
+	c.Timeout = time.Duration(aux.Timeout)
+	c.KeepAlive = time.Duration(aux.KeepAlive)
+	c.IdleTimeout = time.Duration(aux.IdleTimeout)
+	if aux.Password != "" {
+		c.Password = aux.Password
+		c.legacyPassword = true
+	}
+	return nil
+}
+
+type sourceFtp struct {
+	conn   *ftp.ServerConn
+	folder string // relative to the login folder unless absolute
+}
+
+// Opens a folder on an FTP server such as "ftp://user@server:21/folder?passwordSecret=env:FTP_PASSWORD&tls=explicit"
+//
+// Logs in anonymously without a user. The folder is relative to the login folder, use "//folder" for absolute paths.
+// The parameters tls ("explicit" or "implicit"), caFile, certFile, keyFile and disableEpsv match those of the copier
+func openSourceFtp(location *url.URL, secrets *Secrets) (Source, error) {
+	query := location.Query()
+	config := &ftpConfig{
+		server:      location.Host,
+		username:    location.User.Username(),
+		caFile:      query.Get("caFile"),
+		certFile:    query.Get("certFile"),
+		keyFile:     query.Get("keyFile"),
+		disableEpsv: query.Get("disableEpsv") == "true",
+	}
+	switch query.Get("tls") {
+	case "":
+	case "explicit":
+		config.tls = FtpTlsExplicit
+	case "implicit":
+		config.tls = FtpTlsImplicit
+	default:
+		return nil, fmt.Errorf("invalid tls parameter: %s", query.Get("tls"))
+	}
+
+	if config.username == "" {
+		config.username, config.password = "anonymous", "anonymous"
+	}
+	if reference := query.Get("passwordSecret"); reference != "" {
+		var err error
+		config.password, err = secrets.Resolve(reference)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := config.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	source := &sourceFtp{conn: conn, folder: strings.TrimPrefix(location.Path, "/")}
+	if source.folder == "" {
+		source.folder = "."
+	}
+	_, err = conn.List(source.folder)
+	if err != nil {
+		conn.Quit()
+		return nil, fmt.Errorf("unable to read folder: %w", err)
+	}
+	return source, nil
+}
+
+func (s *sourceFtp) List() ([]SourceFile, error) {
+	var files []SourceFile
+	err := s.list("", &files)
+	return files, err
+}
+
+func (s *sourceFtp) list(relDir string, files *[]SourceFile) error {
+	entries, err := s.conn.List(path.Join(s.folder, relDir))
+	if err != nil {
+		return fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.Name == "." || entry.Name == ".." {
+			continue
+		}
+		relPath := path.Join(relDir, entry.Name)
+		switch entry.Type {
+		case ftp.EntryTypeFolder:
+			err = s.list(relPath, files)
+			if err != nil {
+				return err
+			}
+		case ftp.EntryTypeFile:
+			*files = append(*files, SourceFile{Path: relPath, Size: int64(entry.Size), ModTime: entry.Time})
+		}
+	}
+	return nil
+}
+
+// Must be closed before any other call as the connection only handles one transfer at a time
+func (s *sourceFtp) Open(filePath string) (io.ReadCloser, error) {
+	return s.conn.Retr(path.Join(s.folder, filePath))
+}
+
+func (s *sourceFtp) Remove(filePath string) error {
+	return s.conn.Delete(path.Join(s.folder, filePath))
+}
+
+func (s *sourceFtp) Rename(oldPath, newPath string) error {
+	newPath = path.Join(s.folder, newPath)
+	err := ftpMakeDirAll(s.conn, path.Dir(newPath))
+	if err != nil {
+		return err
+	}
+	_ = s.conn.Delete(newPath) // not all servers replace existing files
+	return s.conn.Rename(path.Join(s.folder, oldPath), newPath)
+}
+
+func (s *sourceFtp) Close() error {
+	return s.conn.Quit()
+}
@@ -0,0 +1,766 @@
+package fileMonitor
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/treavorj/zerolog"
+	"github.com/treavorj/zerolog/log"
+	"github.com/treavorj/zerolog/pkgerrors"
+)
+
+// In-process FTP server storing uploads below root
+//
+// Accepts the password "password" for any user. Handles the commands used by the copier and source over plain text or
+// TLS with passive data connections
+type fakeFtp struct {
+	root      string
+	tlsConfig *tls.Config // enables AUTH TLS, or every connection with implicit
+	implicit  bool
+	truncate  bool // drops the last byte of uploads
+
+	lock     sync.Mutex
+	logins   int
+	noops    int
+	sessions map[net.Conn]bool // open control connections
+}
+
+func startFakeFtp(t *testing.T, tlsConfig *tls.Config, implicit bool) (*fakeFtp, string) {
+	t.Helper()
+
+	f := &fakeFtp{root: t.TempDir(), tlsConfig: tlsConfig, implicit: implicit, sessions: make(map[net.Conn]bool)}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			if implicit {
+				conn = tls.Server(conn, tlsConfig)
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f, listener.Addr().String()
+}
+
+func (f *fakeFtp) serve(conn net.Conn) {
+	f.lock.Lock()
+	f.sessions[conn] = true
+	f.lock.Unlock()
+	defer func() {
+		f.lock.Lock()
+		delete(f.sessions, conn)
+		f.lock.Unlock()
+		conn.Close()
+	}()
+
+	control := conn
+	reader := bufio.NewReader(control)
+	reply := func(code int, message string) {
+		_, _ = fmt.Fprintf(control, "%d %s\r\n", code, message)
+	}
+	protected := false
+	cwd := "/"
+	renameFrom := ""
+	var dataListener net.Listener
+	defer func() {
+		if dataListener != nil {
+			dataListener.Close()
+		}
+	}()
+	// accepts the data connection of the last EPSV or PASV, replying with an error if there is none
+	openData := func() net.Conn {
+		if dataListener == nil {
+			reply(425, "use PASV first")
+			return nil
+		}
+		reply(150, "opening data connection")
+		data, err := dataListener.Accept()
+		dataListener.Close()
+		dataListener = nil
+		if err != nil {
+			reply(425, err.Error())
+			return nil
+		}
+		if protected {
+			data = tls.Server(data, f.tlsConfig)
+		}
+		return data
+	}
+
+	reply(220, "ready")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command, argument, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+
+		switch strings.ToUpper(command) {
+		case "AUTH":
+			if f.tlsConfig == nil || f.implicit {
+				reply(502, "TLS not available")
+				continue
+			}
+			reply(234, "starting TLS")
+			control = tls.Server(control, f.tlsConfig)
+			reader = bufio.NewReader(control)
+		case "USER":
+			reply(331, "password required")
+		case "PASS":
+			if argument != "password" {
+				reply(530, "login incorrect")
+				continue
+			}
+			f.lock.Lock()
+			f.logins++
+			f.lock.Unlock()
+			reply(230, "logged in")
+		case "FEAT":
+			_, _ = fmt.Fprintf(control, "211-Features:\r\n UTF8\r\n MLST type*;size*;modify*;\r\n211 End\r\n")
+		case "TYPE", "OPTS", "PBSZ":
+			reply(200, "ok")
+		case "PROT":
+			protected = argument == "P"
+			reply(200, "ok")
+		case "NOOP":
+			f.lock.Lock()
+			f.noops++
+			f.lock.Unlock()
+			reply(200, "ok")
+		case "EPSV", "PASV":
+			if dataListener != nil {
+				dataListener.Close()
+			}
+			dataListener, err = net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				reply(425, err.Error())
+				continue
+			}
+			port := dataListener.Addr().(*net.TCPAddr).Port
+			if command == "EPSV" {
+				reply(229, fmt.Sprintf("Entering Extended Passive Mode (|||%d|)", port))
+			} else {
+				reply(227, fmt.Sprintf("Entering Passive Mode (127,0,0,1,%d,%d)", port/256, port%256))
+			}
+		case "STOR":
+			data := openData()
+			if data == nil {
+				continue
+			}
+			content, err := io.ReadAll(data)
+			data.Close()
+			f.lock.Lock()
+			truncate := f.truncate
+			f.lock.Unlock()
+			if truncate && len(content) > 0 {
+				content = content[:len(content)-1]
+			}
+			if err == nil {
+				err = os.WriteFile(f.resolve(cwd, argument), content, 0644)
+			}
+			if err != nil {
+				reply(553, err.Error())
+				continue
+			}
+			reply(226, "transfer complete")
+		case "RETR":
+			file, err := os.Open(f.resolve(cwd, argument))
+			if err != nil {
+				reply(550, err.Error())
+				continue
+			}
+			data := openData()
+			if data != nil {
+				_, err = io.Copy(data, file)
+				data.Close()
+			}
+			file.Close()
+			if data == nil {
+				continue
+			} else if err != nil {
+				reply(426, err.Error())
+				continue
+			}
+			reply(226, "transfer complete")
+		case "MLSD":
+			entries, err := os.ReadDir(f.resolve(cwd, argument))
+			if err != nil {
+				reply(550, err.Error())
+				continue
+			}
+			data := openData()
+			if data == nil {
+				continue
+			}
+			for _, entry := range entries {
+				info, err := entry.Info()
+				if err != nil {
+					continue
+				}
+				facts := "type=file;size=" + strconv.FormatInt(info.Size(), 10)
+				if entry.IsDir() {
+					facts = "type=dir"
+				}
+				_, _ = fmt.Fprintf(data, "%s;modify=%s; %s\r\n", facts, info.ModTime().UTC().Format("20060102150405"), entry.Name())
+			}
+			data.Close()
+			reply(226, "transfer complete")
+		case "PWD":
+			reply(257, `"`+cwd+`"`)
+		case "CWD":
+			if fileStats, err := os.Stat(f.resolve(cwd, argument)); err != nil || !fileStats.IsDir() {
+				reply(550, "no such directory")
+				continue
+			}
+			cwd = path.Join(cwd, argument)
+			if path.IsAbs(argument) {
+				cwd = path.Clean(argument)
+			}
+			reply(250, "ok")
+		case "MKD":
+			if err := os.Mkdir(f.resolve(cwd, argument), os.ModePerm); err != nil {
+				reply(550, err.Error())
+				continue
+			}
+			reply(257, "created")
+		case "SIZE":
+			fileStats, err := os.Stat(f.resolve(cwd, argument))
+			if err != nil {
+				reply(550, err.Error())
+				continue
+			}
+			reply(213, strconv.FormatInt(fileStats.Size(), 10))
+		case "DELE":
+			if err := os.Remove(f.resolve(cwd, argument)); err != nil {
+				reply(550, err.Error())
+				continue
+			}
+			reply(250, "deleted")
+		case "RNFR":
+			renameFrom = f.resolve(cwd, argument)
+			reply(350, "ready for RNTO")
+		case "RNTO":
+			if err := os.Rename(renameFrom, f.resolve(cwd, argument)); err != nil {
+				reply(550, err.Error())
+				continue
+			}
+			reply(250, "renamed")
+		case "QUIT":
+			reply(221, "bye")
+			return
+		default:
+			reply(502, "not implemented")
+		}
+	}
+}
+
+func (f *fakeFtp) path(ftpPath string) string {
+	return filepath.Join(f.root, filepath.FromSlash(ftpPath))
+}
+
+// Local path of an FTP path relative to the current folder
+func (f *fakeFtp) resolve(cwd, ftpPath string) string {
+	if !path.IsAbs(ftpPath) {
+		ftpPath = path.Join(cwd, ftpPath)
+	}
+	return f.path(ftpPath)
+}
+
+// Closes all control connections as if the server dropped idle clients
+func (f *fakeFtp) dropConnections() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for conn := range f.sessions {
+		conn.Close()
+	}
+}
+
+func (f *fakeFtp) counts() (logins, noops, sessions int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.logins, f.noops, len(f.sessions)
+}
+
+// Creates a CA with a server certificate for 127.0.0.1 and a client certificate
+//
+// Returns the server TLS config requiring the client certificate and the PEM files for the client
+func generateFtpCertificates(t *testing.T) (serverConfig *tls.Config, caFile, certFile, keyFile string) {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("failed to create CA: %v", err)
+	}
+	ca, err := x509.ParseCertificate(caDer)
+	if err != nil {
+		t.Fatalf("failed to parse CA: %v", err)
+	}
+
+	issue := func(serial int64, usage x509.ExtKeyUsage) (certPem, keyPem []byte) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("failed to generate key: %v", err)
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "127.0.0.1"},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatalf("failed to create certificate: %v", err)
+		}
+		keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatalf("failed to marshal key: %v", err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})
+	}
+
+	serverCert, serverKey := issue(2, x509.ExtKeyUsageServerAuth)
+	serverCertificate, err := tls.X509KeyPair(serverCert, serverKey)
+	if err != nil {
+		t.Fatalf("failed to load server certificate: %v", err)
+	}
+	clientCas := x509.NewCertPool()
+	clientCas.AddCert(ca)
+	serverConfig = &tls.Config{
+		Certificates: []tls.Certificate{serverCertificate},
+		ClientCAs:    clientCas,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+
+	folder := t.TempDir()
+	clientCert, clientKey := issue(3, x509.ExtKeyUsageClientAuth)
+	files := map[string][]byte{
+		"ca.pem":     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDer}),
+		"client.pem": clientCert,
+		"client.key": clientKey,
+	}
+	for name, data := range files {
+		err = os.WriteFile(filepath.Join(folder, name), data, 0600)
+		if err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	return serverConfig, filepath.Join(folder, "ca.pem"), filepath.Join(folder, "client.pem"), filepath.Join(folder, "client.key")
+}
+
+func TestCopierFtp(t *testing.T) {
+	t.Parallel()
+
+	serverConfig, caFile, certFile, keyFile := generateFtpCertificates(t)
+	monitorFolder := t.TempDir()
+	for n := 0; n < 4; n++ {
+		err := os.WriteFile(filepath.Join(monitorFolder, fmt.Sprintf("ftp%d.csv", n)), []byte("a,b\n1,2\n"), os.ModePerm)
+		if err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		tls      FtpTls
+		implicit bool
+	}{
+		{"plain", FtpTlsNone, false},
+		{"explicit", FtpTlsExplicit, false},
+		{"implicit", FtpTlsImplicit, true},
+	}
+	for _, test := range tests {
+		var tlsConfig *tls.Config
+		if test.tls != FtpTlsNone {
+			tlsConfig = serverConfig
+		}
+		server, addr := startFakeFtp(t, tlsConfig, test.implicit)
+		copier := &CopierFtp{
+			Server:         addr,
+			Username:       "user",
+			Password:       "password",
+			Tls:            test.tls,
+			CaFile:         caFile,
+			CertFile:       certFile,
+			KeyFile:        keyFile,
+			MaxConnections: 2,
+			KeepAlive:      time.Millisecond * 20,
+		}
+		err := copier.Validate()
+		if err != nil {
+			t.Errorf("%s: failed to validate copier: %v", test.name, err)
+			continue
+		}
+
+		var wait sync.WaitGroup
+		for n := 0; n < 4; n++ {
+			wait.Add(1)
+			go func() {
+				defer wait.Done()
+				err := copier.Copy(filepath.Join(monitorFolder, fmt.Sprintf("ftp%d.csv", n)), monitorFolder)
+				if err != nil {
+					t.Errorf("%s: failed to copy file: %v", test.name, err)
+				}
+			}()
+		}
+		wait.Wait()
+		for n := 0; n < 4; n++ {
+			data, err := os.ReadFile(server.path(fmt.Sprintf("ftp%d.csv", n)))
+			if err != nil || string(data) != "a,b\n1,2\n" {
+				t.Errorf("%s: unexpected uploaded file: %q, %v", test.name, data, err)
+			}
+		}
+		if logins, _, _ := server.counts(); logins > 3 { // includes the connection of Validate
+			t.Errorf("%s: expected the pool to reuse at most 2 connections but logged in %d times", test.name, logins)
+		}
+
+		time.Sleep(time.Millisecond * 100)
+		if _, noops, _ := server.counts(); noops == 0 {
+			t.Errorf("%s: expected idle connections to be kept alive", test.name)
+		}
+
+		err = copier.Close()
+		if err != nil {
+			t.Errorf("%s: failed to close copier: %v", test.name, err)
+		}
+		time.Sleep(time.Millisecond * 50)
+		if _, _, sessions := server.counts(); sessions != 0 {
+			t.Errorf("%s: expected closing the copier to quit its connections but %d are open", test.name, sessions)
+		}
+	}
+
+	// connections dropped by the server while idle are replaced
+	server, addr := startFakeFtp(t, nil, false)
+	copier := &CopierFtp{
+		Server:      addr,
+		Username:    "user",
+		Password:    "password",
+		KeepAlive:   time.Millisecond * 50,
+		IdleTimeout: time.Millisecond * 200,
+	}
+	filePath := filepath.Join(monitorFolder, "ftp0.csv")
+	err := copier.Copy(filePath, monitorFolder)
+	if err != nil {
+		t.Fatalf("failed to copy file: %v", err)
+	}
+	server.dropConnections()
+	err = copier.Copy(filePath, monitorFolder)
+	if err != nil {
+		t.Errorf("expected the copier to reconnect but got: %v", err)
+	}
+	if logins, _, _ := server.counts(); logins != 2 {
+		t.Errorf("expected a new login after the connection was dropped but got %d logins", logins)
+	}
+
+	time.Sleep(time.Millisecond * 500)
+	if _, _, sessions := server.counts(); sessions != 0 {
+		t.Errorf("expected idle connections to be closed after IdleTimeout but %d are open", sessions)
+	}
+
+	invalid := &CopierFtp{Server: addr, Username: "user", Password: "wrong"}
+	if err := invalid.Validate(); err == nil {
+		t.Errorf("expected a wrong password to fail")
+	}
+	_, tlsAddr := startFakeFtp(t, serverConfig, false)
+	invalid = &CopierFtp{Server: tlsAddr, Username: "user", Password: "password", Tls: FtpTlsExplicit}
+	if err := invalid.Validate(); err == nil {
+		t.Errorf("expected a server certificate from an unknown CA to be rejected")
+	}
+}
+
+func TestCopierFtpRemoveDir(t *testing.T) {
+	t.Parallel()
+
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+	logger := log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339Nano}).With().Caller().Logger()
+
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(configFile, []byte("{}"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	fileMonitor, err := NewFileMonitor(context.Background(), logger, configFile)
+	if err != nil {
+		t.Fatalf("failed to create fileMonitor: %v", err)
+	} else if fileMonitor == nil {
+		t.Fatalf("fileMonitor is nil")
+	}
+
+	server, addr := startFakeFtp(t, nil, false)
+	monitorFolder := t.TempDir()
+	dir := &Dir{
+		Name:             t.Name(),
+		MonitorFolder:    monitorFolder,
+		MonitorFrequency: time.Millisecond * 50,
+		Copiers:          []Copier{&CopierFtp{Server: addr, Username: "user", Password: "password"}},
+	}
+	err = fileMonitor.AddDir(dir)
+	if err != nil {
+		t.Fatalf("failed to add dir: %v", err)
+	}
+	err = os.WriteFile(filepath.Join(monitorFolder, "ftp.csv"), []byte("a,b\n1,2\n"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	time.Sleep(time.Millisecond * 500)
+	if _, err := os.Stat(server.path("ftp.csv")); err != nil {
+		t.Fatalf("file should have been uploaded: %v", err)
+	}
+	if _, _, sessions := server.counts(); sessions != 1 {
+		t.Errorf("expected the pooled connection to stay open but %d are open", sessions)
+	}
+
+	err = fileMonitor.RemoveDir(dir)
+	if err != nil {
+		t.Fatalf("failed to remove dir: %v", err)
+	}
+	time.Sleep(time.Millisecond * 50)
+	if _, _, sessions := server.counts(); sessions != 0 {
+		t.Errorf("expected removing the dir to close the pool but %d connections are open", sessions)
+	}
+}
+
+func TestCopierFtpNested(t *testing.T) {
+	t.Parallel()
+
+	monitorFolder := t.TempDir()
+	filePath := filepath.Join(monitorFolder, "sub", "deeper", "ftp.csv")
+	err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to create sub folder: %v", err)
+	}
+	err = os.WriteFile(filePath, []byte("a,b\n1,2\n"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	server, addr := startFakeFtp(t, nil, false)
+	err = os.WriteFile(server.path("existing.csv"), []byte("old"), 0644)
+	if err != nil {
+		t.Fatalf("failed to write existing file: %v", err)
+	}
+	copier := &CopierFtp{Server: addr, Username: "user", Password: "password", Destination: "/archive"}
+	err = copier.Copy(filePath, monitorFolder)
+	if err != nil {
+		t.Fatalf("failed to copy file: %v", err)
+	}
+	data, err := os.ReadFile(server.path("archive/sub/deeper/ftp.csv"))
+	if err != nil || string(data) != "a,b\n1,2\n" {
+		t.Errorf("unexpected uploaded file: %q, %v", data, err)
+	}
+	if _, err := os.Stat(server.path("archive/sub/deeper/ftp.csv.part")); !os.IsNotExist(err) {
+		t.Errorf("temporary file should have been renamed: %v", err)
+	}
+
+	// existing files are detected with SIZE
+	copier.OnConflict = ConflictRenameCounter
+	err = copier.Copy(filePath, monitorFolder)
+	if err != nil {
+		t.Fatalf("failed to copy file with a counter: %v", err)
+	}
+	if _, err := os.Stat(server.path("archive/sub/deeper/ftp_1.csv")); err != nil {
+		t.Errorf("expected a copy with a counter: %v", err)
+	}
+	copier.OnConflict = ConflictOverwrite
+
+	// uploads which do not match the local size are removed
+	server.lock.Lock()
+	server.truncate = true
+	server.lock.Unlock()
+	copier.Destination = "/truncated"
+	err = copier.Copy(filePath, monitorFolder)
+	if err == nil || !strings.Contains(err.Error(), "expected 8") {
+		t.Errorf("expected a truncated upload to fail but got: %v", err)
+	}
+	for _, name := range []string{"truncated/sub/deeper/ftp.csv", "truncated/sub/deeper/ftp.csv.part"} {
+		if _, err := os.Stat(server.path(name)); !os.IsNotExist(err) {
+			t.Errorf("%s should not exist after a failed upload: %v", name, err)
+		}
+	}
+}
+
+func TestSourceFtp(t *testing.T) {
+	t.Setenv("TEST_SOURCE_FTP_PASSWORD", "password")
+	server, addr := startFakeFtp(t, nil, false)
+	files := map[string]string{"remote/sub/remote.csv": "a,b\n1,2\n", "remote/ignored.txt": "ignored", "remote/archive/sub/remote.csv": "previous"}
+	for name, content := range files {
+		err := os.MkdirAll(filepath.Dir(server.path(name)), os.ModePerm)
+		if err != nil {
+			t.Fatalf("failed to create folder: %v", err)
+		}
+		err = os.WriteFile(server.path(name), []byte(content), os.ModePerm)
+		if err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	location, err := url.Parse("ftp://user@" + addr + "/remote?passwordSecret=env:TEST_SOURCE_FTP_PASSWORD")
+	if err != nil {
+		t.Fatalf("failed to parse url: %v", err)
+	}
+	source, err := openSourceFtp(location, newSecrets())
+	if err != nil {
+		t.Fatalf("failed to open source: %v", err)
+	}
+	defer source.Close()
+
+	listed, err := source.List()
+	if err != nil {
+		t.Fatalf("failed to list files: %v", err)
+	}
+	sizes := make(map[string]int64)
+	for _, file := range listed {
+		sizes[file.Path] = file.Size
+	}
+	if len(sizes) != 3 || sizes["sub/remote.csv"] != 8 || sizes["ignored.txt"] != 7 {
+		t.Errorf("unexpected listed files: %v", sizes)
+	}
+
+	reader, err := source.Open("sub/remote.csv")
+	if err != nil {
+		t.Fatalf("failed to open file: %v", err)
+	}
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil || string(data) != "a,b\n1,2\n" {
+		t.Errorf("unexpected file content: %q, %v", data, err)
+	}
+
+	// existing files are replaced and missing folders created
+	for _, newPath := range []string{"archive/sub/remote.csv", "other/nested/remote.csv"} {
+		err = source.Rename("sub/remote.csv", newPath)
+		if err != nil {
+			t.Fatalf("failed to rename file to %s: %v", newPath, err)
+		}
+		data, err = os.ReadFile(server.path(path.Join("remote", newPath)))
+		if err != nil || string(data) != "a,b\n1,2\n" {
+			t.Errorf("unexpected renamed file %s: %q, %v", newPath, data, err)
+		}
+		err = source.Rename(newPath, "sub/remote.csv")
+		if err != nil {
+			t.Fatalf("failed to rename file back: %v", err)
+		}
+	}
+
+	err = source.Remove("ignored.txt")
+	if err != nil {
+		t.Errorf("failed to remove file: %v", err)
+	} else if _, err := os.Stat(server.path("remote/ignored.txt")); !os.IsNotExist(err) {
+		t.Errorf("file should have been removed: %v", err)
+	}
+}
+
+func TestSourceFtpDir(t *testing.T) {
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+	logger := log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339Nano}).With().Caller().Logger()
+
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(configFile, []byte("{}"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	fileMonitor, err := NewFileMonitor(context.Background(), logger, configFile)
+	if err != nil {
+		t.Fatalf("failed to create fileMonitor: %v", err)
+	}
+
+	t.Setenv("TEST_SOURCE_FTP_DIR_PASSWORD", "password")
+	server, addr := startFakeFtp(t, nil, false)
+	files := map[string]string{"remote/sub/remote.csv": "a,b\n1,2\n", "remote/ignored.txt": "ignored"}
+	for name, content := range files {
+		err = os.MkdirAll(filepath.Dir(server.path(name)), os.ModePerm)
+		if err != nil {
+			t.Fatalf("failed to create folder: %v", err)
+		}
+		err = os.WriteFile(server.path(name), []byte(content), os.ModePerm)
+		if err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	destination := t.TempDir()
+	dir := &Dir{
+		Name:             t.Name(),
+		MonitorFolder:    "ftp://user@" + addr + "/remote?passwordSecret=env:TEST_SOURCE_FTP_DIR_PASSWORD",
+		StagingFolder:    t.TempDir(),
+		MonitorFrequency: time.Millisecond * 50,
+		MatchGroups:      []MatchGroup{{Expression: `\.csv$`}},
+		Stability:        Stability{Observations: 2},
+		OnSuccess:        Disposition{Action: DispositionArchive, ArchiveFolder: "archive"},
+		Copiers:          []Copier{&CopierLocal{Destination: destination}},
+	}
+	dir.useSecrets(fileMonitor.secrets)
+	if errs := dir.Validate(); len(errs) != 0 {
+		t.Fatalf("expected the ftp dir to be valid: %v", errs)
+	}
+	err = fileMonitor.AddDir(dir)
+	if err != nil {
+		t.Fatalf("failed to add dir: %v", err)
+	}
+
+	time.Sleep(time.Millisecond * 500)
+	data, err := os.ReadFile(filepath.Join(destination, "sub", "remote.csv"))
+	if err != nil || string(data) != "a,b\n1,2\n" {
+		t.Fatalf("remote file should have been copied: %q, %v", data, err)
+	}
+
+	if _, err := os.Stat(server.path("remote/sub/remote.csv")); !os.IsNotExist(err) {
+		t.Errorf("remote file should have been moved: %v", err)
+	}
+	if _, err := os.Stat(server.path("remote/archive/sub/remote.csv")); err != nil {
+		t.Errorf("remote file should have been archived on the server: %v", err)
+	}
+	if _, err := os.Stat(server.path("remote/archive/archive")); !os.IsNotExist(err) {
+		t.Errorf("archived file should not have been pulled again: %v", err)
+	}
+	if _, err := os.Stat(server.path("remote/ignored.txt")); err != nil {
+		t.Errorf("file not matching should have been left on the server: %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package fileMonitor
+
+import (
+	"slices"
+	"sync"
+)
+
+// Identifies a file independent of its path
+type fileId struct {
+	device uint64
+	inode  uint64
+}
+
+// Files scheduled or being processed. Shared by a Dir and its replacement when the config is reloaded
+type inFlightSet struct {
+	lock  sync.Mutex
+	paths map[string]fileId // keyed by file path
+	ids   map[fileId]string
+}
+
+func newInFlightSet() *inFlightSet {
+	return &inFlightSet{
+		paths: make(map[string]fileId),
+		ids:   make(map[fileId]string),
+	}
+}
+
+// Checks if the path is currently being processed
+func (d *Dir) isInFlight(filePath string) bool {
+	d.inFlight.lock.Lock()
+	defer d.inFlight.lock.Unlock()
+
+	_, ok := d.inFlight.paths[filePath]
+	return ok
+}
+
+// Marks the file as being processed. Returns false if either the path or the underlying file is already being processed
+func (d *Dir) acquire(filePath string) bool {
+	id, err := getFileId(filePath)
+	if err != nil {
+		d.log.Trace().Err(err).Str("filePath", filePath).Msg("unable to get file id")
+		return false
+	}
+
+	d.inFlight.lock.Lock()
+	defer d.inFlight.lock.Unlock()
+
+	if _, ok := d.inFlight.paths[filePath]; ok {
+		return false
+	}
+	if _, ok := d.inFlight.ids[id]; ok {
+		return false
+	}
+	d.inFlight.paths[filePath] = id
+	d.inFlight.ids[id] = filePath
+	return true
+}
+
+// Marks the file as no longer being processed
+func (d *Dir) release(filePath string) {
+	d.inFlight.lock.Lock()
+	defer d.inFlight.lock.Unlock()
+
+	id, ok := d.inFlight.paths[filePath]
+	if !ok {
+		return
+	}
+	delete(d.inFlight.paths, filePath)
+	delete(d.inFlight.ids, id)
+}
+
+// Original paths of all files currently scheduled, being processed or parked for a retry
+func (d *Dir) inFlightPaths() []string {
+	if d.inFlight == nil {
+		return nil
+	}
+	d.inFlight.lock.Lock()
+	defer d.inFlight.lock.Unlock()
+
+	filePaths := make([]string, 0, len(d.inFlight.paths))
+	for filePath := range d.inFlight.paths {
+		filePaths = append(filePaths, filePath)
+	}
+	slices.Sort(filePaths)
+	return filePaths
+}
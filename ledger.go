@@ -0,0 +1,210 @@
+package fileMonitor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/treavorj/zerolog"
+	"go.etcd.io/bbolt"
+)
+
+type DuplicatePolicy int
+
+const (
+	DuplicatePolicyProcess DuplicatePolicy = iota // Processes files again even if they were already completed
+	DuplicatePolicySkip                           // Skips files which were already completed and disposes of them as successful
+	DuplicatePolicyFlag                           // Processes files again but logs a warning and counts them in Stats
+)
+
+// Result of a single publisher or copier for a file
+type StageResult struct {
+	Done  bool
+	Error string `json:",omitempty"`
+	Time  time.Time
+}
+
+func (s *StageResult) set(err error) {
+	s.Done = err == nil
+	s.Error = ""
+	if err != nil {
+		s.Error = err.Error()
+	}
+	s.Time = time.Now()
+}
+
+// Progress of a file through a Dir
+type LedgerRecord struct {
+	Hash       string // SHA-256 of the file content
+	Path       string
+	Size       int64
+	Ids        []string // ids returned by the Processor
+	Processed  bool
+	Publishers []StageResult // indexed the same as Dir.Publishers
+	Copiers    []StageResult // indexed the same as Dir.Copiers
+	Completed  bool
+
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// Clears all progress so the file is processed from the start
+func (r *LedgerRecord) reset() {
+	r.Ids = nil
+	r.Processed = false
+	r.Publishers = nil
+	r.Copiers = nil
+	r.Completed = false
+}
+
+func (r *LedgerRecord) started() bool {
+	if r.Processed {
+		return true
+	}
+	for _, stages := range [][]StageResult{r.Publishers, r.Copiers} {
+		for _, stage := range stages {
+			if stage.Done {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *LedgerRecord) publishersDone() bool {
+	for _, stage := range r.Publishers {
+		if !stage.Done {
+			return false
+		}
+	}
+	return true
+}
+
+// Sizes the stage results to the current number of publishers and copiers
+func (r *LedgerRecord) resize(publishers, copiers int) {
+	r.Publishers = append(r.Publishers, make([]StageResult, max(publishers-len(r.Publishers), 0))...)[:publishers]
+	r.Copiers = append(r.Copiers, make([]StageResult, max(copiers-len(r.Copiers), 0))...)[:copiers]
+}
+
+// Embedded on-disk store of LedgerRecords keyed by Dir name and content hash
+type Ledger struct {
+	db *bbolt.DB
+}
+
+func OpenLedger(path string) (*Ledger, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("unable to open ledger: %w", err)
+	}
+	return &Ledger{db: db}, nil
+}
+
+func (l *Ledger) Close() error {
+	return l.db.Close()
+}
+
+// Returns nil if no record exists
+func (l *Ledger) Get(dirName, hash string) (*LedgerRecord, error) {
+	var record *LedgerRecord
+	err := l.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(dirName))
+		if bucket == nil {
+			return nil
+		}
+		data := bucket.Get([]byte(hash))
+		if data == nil {
+			return nil
+		}
+		record = &LedgerRecord{}
+		return json.Unmarshal(data, record)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get record: %w", err)
+	}
+	return record, nil
+}
+
+func (l *Ledger) Put(dirName string, record *LedgerRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("unable to marshal record: %w", err)
+	}
+
+	return l.db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(dirName))
+		if err != nil {
+			return fmt.Errorf("unable to create bucket: %w", err)
+		}
+		return bucket.Put([]byte(record.Hash), data)
+	})
+}
+
+func hashFile(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Loads the record for the file from the ledger or creates a new one
+//
+// Without a ledger the record only tracks progress in memory and the file is not hashed. Unfinished progress is only
+// resumed for the same path as records are shared by files with the same content
+func (d *Dir) loadRecord(filePath string, size int64) (*LedgerRecord, error) {
+	ledger := d.parent.ledger
+	record := &LedgerRecord{
+		Path:      filePath,
+		Size:      size,
+		FirstSeen: time.Now(),
+	}
+
+	if ledger != nil {
+		hash, err := hashFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to hash file: %w", err)
+		}
+
+		existing, err := ledger.Get(d.Name, hash)
+		if err != nil {
+			return nil, err
+		} else if existing != nil {
+			record = existing
+			if !record.Completed && record.Path != filePath {
+				// progress of an identical file elsewhere, possibly still being processed by another worker
+				record.reset()
+			}
+			record.Path = filePath
+		}
+		record.Hash = hash
+	}
+
+	record.LastSeen = time.Now()
+	record.resize(len(d.publishers()), len(d.Copiers))
+	return record, nil
+}
+
+// Saves the record to the ledger if one is configured
+//
+// Saved after each completed stage so a crash only repeats the unfinished stages
+func (d *Dir) saveRecord(record *LedgerRecord, fileLog zerolog.Logger) {
+	if d.parent.ledger == nil {
+		return
+	}
+
+	err := d.parent.ledger.Put(d.Name, record)
+	if err != nil {
+		fileLog.Error().Err(err).Msg("failed to save ledger record")
+	}
+}
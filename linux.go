@@ -4,6 +4,7 @@ package fileMonitor
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -34,6 +35,71 @@ func getCreationTime(filePath string) (time.Time, error) {
 	return time.Unix(stat.Mtim.Unix()), nil // Used Mtim due to Ctim not being reliable
 }
 
+// Copies the owner and extended attributes of inFile to outFile
+//
+// Errors from missing permissions or file systems without extended attributes are ignored
+func copyFileMetadata(inFile, outFile *os.File, inStats os.FileInfo) error {
+	stat := inStats.Sys().(*syscall.Stat_t)
+	err := outFile.Chown(int(stat.Uid), int(stat.Gid))
+	if err != nil && !errors.Is(err, os.ErrPermission) {
+		return fmt.Errorf("unable to change owner: %w", err)
+	}
+
+	size, err := unix.Flistxattr(int(inFile.Fd()), nil)
+	if err != nil || size == 0 {
+		return nil // not supported
+	}
+	names := make([]byte, size)
+	size, err = unix.Flistxattr(int(inFile.Fd()), names)
+	if err != nil {
+		return nil
+	}
+
+	for _, name := range strings.Split(strings.TrimRight(string(names[:size]), "\x00"), "\x00") {
+		size, err := unix.Fgetxattr(int(inFile.Fd()), name, nil)
+		if err != nil {
+			continue
+		}
+		value := make([]byte, size)
+		size, err = unix.Fgetxattr(int(inFile.Fd()), name, value)
+		if err != nil {
+			continue
+		}
+		err = unix.Fsetxattr(int(outFile.Fd()), name, value[:size], 0)
+		if err != nil && !errors.Is(err, os.ErrPermission) && !errors.Is(err, unix.ENOTSUP) {
+			return fmt.Errorf("unable to copy extended attribute %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func getFileId(filePath string) (fileId, error) {
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return fileId{}, err
+	}
+
+	stat := fileInfo.Sys().(*syscall.Stat_t)
+	return fileId{device: uint64(stat.Dev), inode: stat.Ino}, nil
+}
+
+// Checks if the file can be exclusively locked. Only detects advisory locks held by other processes
+func probeExclusive(filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return unix.Flock(int(file.Fd()), unix.LOCK_EX|unix.LOCK_NB) // released on close
+}
+
+// Checks files can be created in the folder without creating one
+func checkWritable(folder string) error {
+	return unix.Access(folder, unix.W_OK|unix.X_OK)
+}
+
+// Deprecated: requires root and stores the credentials on the host. Use CopierSmb or an smb:// MonitorFolder instead
 func SmbMount(username, password, server, shareName string) error {
 	if shareName == "" {
 		return fmt.Errorf("shareName cannot be blank")
@@ -108,6 +174,7 @@ func addFstabEntry(server, shareName, mountPoint, credentialsFile string) error
 	return nil
 }
 
+// Deprecated: only needed for shares mounted with SmbMount
 func SmbRemove(server, shareName string) error {
 	if shareName == "" {
 		return fmt.Errorf("shareName cannot be blank")
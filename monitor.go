@@ -2,7 +2,7 @@ package fileMonitor
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"os"
@@ -18,15 +18,34 @@ const (
 )
 
 type FileMonitor struct {
-	Dirs       map[string]*Dir
-	MaxJobs    uint // maximum number of jobs that can be buffered without waiting
-	NumWorkers uint // number of workers processing files
+	Version    int             // of the config document. Older configs are migrated when loaded
+	Dirs       map[string]*Dir // Use GetDir and GetDirs while running as the map is changed by reloads
+	MaxJobs    uint            // maximum number of jobs that can be buffered without waiting
+	NumWorkers uint            // number of workers processing files
+	LedgerPath string          // optional on-disk ledger of processed files used to skip duplicates and resume unfinished files
+
+	ReloadFrequency time.Duration // how often the config file is checked for changes to the Dirs. 0 disables reloading
+	ConfigBackups   uint          // number of previous config files kept when updating, named like config.1.json with 1 the newest
+
+	ledger  *Ledger
+	secrets *Secrets
+
+	dirsLock sync.RWMutex // guards the Dirs map
 
 	workerWg    sync.WaitGroup
 	workerTasks chan func(worker uint)
+	tasks       taskCounter // tasks queued or being processed
+
+	retryLock      sync.Mutex
+	retries        map[*fileTask]parkedRetry
+	retriesStopped bool // set by Stop so parked tasks are no longer added to the workers
+
+	watchCancel context.CancelFunc
+	watchDone   chan struct{} // closed once the config is no longer polled
 
 	configLock sync.Mutex
 	configPath string
+	configHash [sha256.Size]byte // of the config last read or written so own writes are not reloaded
 
 	logger    zerolog.Logger
 	ctxParent context.Context
@@ -46,98 +65,242 @@ func (f *FileMonitor) Connected() bool {
 	}
 }
 
+// Starts the workers. Tasks still queued from before a Stop are kept
 func (f *FileMonitor) startWorkers() {
-	if f.ctx != nil {
-		select {
-		case <-f.ctx.Done():
-			f.logger.Trace().Msg("restring workers")
-		default:
-			f.logger.Warn().Msg("workers already running")
-			return
-		}
+	if f.Connected() {
+		f.logger.Warn().Msg("workers already running")
+		return
+	} else if f.ctx != nil {
+		f.logger.Trace().Msg("restarting workers")
 	}
 	f.ctx, f.ctxCancel = context.WithCancel(f.ctxParent)
+	f.retryLock.Lock()
+	f.retriesStopped = false
+	f.retryLock.Unlock()
 
 	f.logger.Info().Uint("NumWorkers", f.NumWorkers).Uint("MaxJobs", f.MaxJobs).Msg("starting workers")
 
 	if f.workerTasks == nil {
 		f.workerTasks = make(chan func(worker uint), f.MaxJobs)
-	} else {
-		if task, ok := <-f.workerTasks; !ok {
-			f.workerTasks = make(chan func(worker uint), f.MaxJobs)
-		} else {
-			f.workerTasks <- task
-		}
 	}
 
 	f.workerWg.Add(int(f.NumWorkers))
 	for i := uint(0); i < f.NumWorkers; i++ {
-		go f.worker(i)
+		go f.worker(f.ctx, i)
 	}
 
 	f.logger.Info().Msg("started all workers")
-
-	go func() {
-		f.workerWg.Wait()
-		f.ctxCancel()
-	}()
 }
 
-func (f *FileMonitor) worker(worker uint) {
+func (f *FileMonitor) worker(ctx context.Context, worker uint) {
 	defer f.workerWg.Done()
 
 	for {
 		select {
-		case task, ok := <-f.workerTasks:
-			if !ok {
-				f.logger.Warn().Msg("worker closed due to closed channel. Should close with context")
-			}
+		case task := <-f.workerTasks:
 			task(worker)
-		case <-f.ctx.Done():
-			f.logger.Info().Msg("closing workers with context")
+			f.tasks.done()
+		case <-ctx.Done():
+			f.logger.Info().Uint("worker", worker).Msg("closing worker with context")
 			return
 		}
 	}
 }
 
+// Queues the task for the workers. Returns false if ctx is done before the task could be queued
+func (f *FileMonitor) queue(ctx context.Context, task func(worker uint)) bool {
+	f.tasks.add()
+	return f.send(ctx, task)
+}
+
+// Sends a task already counted in tasks to the workers
+func (f *FileMonitor) send(ctx context.Context, task func(worker uint)) bool {
+	select {
+	case f.workerTasks <- task:
+		return true
+	case <-ctx.Done():
+		f.tasks.done()
+		return false
+	}
+}
+
+// Counts tasks like a sync.WaitGroup but can be waited on with a deadline
+type taskCounter struct {
+	lock  sync.Mutex
+	count int
+	idle  chan struct{} // closed once count reaches 0
+}
+
+func (c *taskCounter) add() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.count++
+}
+
+func (c *taskCounter) done() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.count--
+	if c.count == 0 && c.idle != nil {
+		close(c.idle)
+		c.idle = nil
+	}
+}
+
+// Closed once no tasks are left
+func (c *taskCounter) drained() <-chan struct{} {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.count == 0 {
+		idle := make(chan struct{})
+		close(idle)
+		return idle
+	} else if c.idle == nil {
+		c.idle = make(chan struct{})
+	}
+	return c.idle
+}
+
 func (f *FileMonitor) Update() error {
 	if f.configPath == "" {
 		return fmt.Errorf("file location cannot be empty")
 	}
 
-	data, err := json.Marshal(f)
+	f.configLock.Lock()
+	defer f.configLock.Unlock()
+	f.Version = ConfigVersion
+	f.dirsLock.RLock()
+	data, err := configFormatOf(f.configPath).marshal(f)
+	f.dirsLock.RUnlock()
 	if err != nil {
 		return fmt.Errorf("unable to marshal data: %w", err)
 	}
 
-	f.configLock.Lock()
-	defer f.configLock.Unlock()
-	err = os.WriteFile(f.configPath, data, os.ModePerm)
+	err = rotateConfigBackups(f.configPath, f.ConfigBackups)
+	if err != nil {
+		f.logger.Warn().Err(err).Msg("failed to back up configuration")
+	}
+	err = writeFileAtomic(f.configPath, data, configFileMode)
 	if err != nil {
 		f.logger.Warn().Err(err).Msg("failed to update configuration")
 		return err
 	}
+	f.configHash = sha256.Sum256(data)
 	f.logger.Info().Msg("updated config")
 	return nil
 }
 
 func (f *FileMonitor) Start() error {
+	f.dirsLock.Lock()
 	if f.Dirs == nil {
 		f.Dirs = make(map[string]*Dir)
 	}
+	f.dirsLock.Unlock()
+	if f.LedgerPath != "" && f.ledger == nil {
+		ledger, err := OpenLedger(expandEnv(f.LedgerPath))
+		if err != nil {
+			return err
+		}
+		f.ledger = ledger
+	}
 	f.startWorkers()
+	f.releaseRetries() // parked by workers still running when a previous Stop timed out
 
 	f.logger.Info().Msg("Starting monitor of all dirs")
 
-	for _, dir := range f.Dirs {
+	for _, dir := range f.dirList() {
 		dir.parent = f
-		dir.Monitor()
+		err := dir.Monitor()
+		if err != nil {
+			f.logger.Error().Err(err).Str("dir", dir.Name).Msg("failed to start monitoring dir")
+		}
 	}
 
+	var watchCtx context.Context
+	watchCtx, f.watchCancel = context.WithCancel(f.ctx)
+	f.watchDone = make(chan struct{})
+	go func() {
+		defer close(f.watchDone)
+		f.watchConfig(watchCtx)
+	}()
+
 	f.logger.Info().Msg("successfully started monitoring all dirs")
 	return nil
 }
 
+// Stops scanning all dirs and waits for queued and in-flight files to finish until ctx is done
+//
+// Returns the original paths of files left unprocessed keyed by dir name. These are files still being processed
+// when ctx was done, files which were still queued and files parked for a retry. They are picked up again once started.
+// The FileMonitor can be started again with Start
+//
+// The ledger is closed once all workers returned. It is left open if ctx is done first as workers may still use it
+func (f *FileMonitor) Stop(ctx context.Context) (unprocessed map[string][]string, err error) {
+	if !f.Connected() {
+		return nil, fmt.Errorf("fileMonitor is not running")
+	}
+	f.logger.Info().Msg("stopping fileMonitor")
+
+	// nothing may add tasks while draining
+	f.watchCancel()
+	<-f.watchDone
+	for _, dir := range f.dirList() {
+		dir.Stop()
+	}
+	f.stopRetries()
+
+	workersDone := false
+	select {
+	case <-f.tasks.drained():
+		f.ctxCancel()
+		f.workerWg.Wait()
+		workersDone = true
+		unprocessed = f.unprocessed() // only files parked for a retry
+	case <-ctx.Done():
+		err = fmt.Errorf("stopped before all files finished: %w", ctx.Err())
+		unprocessed = f.unprocessed()
+		f.ctxCancel()
+
+		// files still queued are not claimed so are dropped and picked up again on the next start
+		for dropped := false; !dropped; {
+			select {
+			case task := <-f.workerTasks:
+				task(0)
+				f.tasks.done()
+			default:
+				dropped = true
+			}
+		}
+	}
+
+	f.releaseRetries()
+
+	if f.ledger != nil && workersDone {
+		closeErr := f.ledger.Close()
+		if closeErr != nil {
+			f.logger.Error().Err(closeErr).Msg("failed to close ledger")
+		}
+		f.ledger = nil
+	}
+
+	f.logger.Info().Int("dirsUnprocessed", len(unprocessed)).Msg("stopped fileMonitor")
+	return unprocessed, err
+}
+
+// Files of all dirs which are scheduled, being processed or parked for a retry keyed by dir name
+func (f *FileMonitor) unprocessed() map[string][]string {
+	unprocessed := make(map[string][]string)
+	for _, dir := range f.dirList() {
+		filePaths := dir.inFlightPaths()
+		if len(filePaths) == 0 {
+			continue
+		}
+		unprocessed[dir.Name] = filePaths
+		dir.log.Warn().Strs("unprocessed", filePaths).Msg("files left unprocessed after stopping")
+	}
+	return unprocessed
+}
+
 func NewFileMonitor(parentCtx context.Context, logger zerolog.Logger, configPath string) (*FileMonitor, error) {
 	if configPath == "" {
 		return nil, fmt.Errorf("configPath cannot be empty")
@@ -149,7 +312,7 @@ func NewFileMonitor(parentCtx context.Context, logger zerolog.Logger, configPath
 		return nil, nil
 	}
 
-	fileMonitor := FileMonitor{}
+	fileMonitor := FileMonitor{secrets: newSecrets()}
 
 	fileMonitor.configLock.Lock()
 	defer fileMonitor.configLock.Unlock()
@@ -165,31 +328,40 @@ func NewFileMonitor(parentCtx context.Context, logger zerolog.Logger, configPath
 		return nil, fmt.Errorf("error reading data from file: %w", err)
 	}
 
-	err = json.Unmarshal(fileData, &fileMonitor)
+	err = configFormatOf(configPath).unmarshal(fileData, &fileMonitor)
 	if err != nil {
 		return nil, fmt.Errorf("unable to unmarshal fileMonitor config file: %w", err)
 	}
+	fileMonitor.configHash = sha256.Sum256(fileData)
 
 	fileMonitor.logger = logger.With().Str("app", "fileMonitor").DeDup().Logger()
-	if fileMonitor.NumWorkers == 0 {
-		fileMonitor.NumWorkers = uint(max(runtime.NumCPU()/2, 1))
-	} else if fileMonitor.NumWorkers > uint(runtime.NumCPU()) {
-		fileMonitor.logger.Warn().
-			Uint("NumWorkersOld", fileMonitor.NumWorkers).
-			Int("NumWorkers", runtime.NumCPU()).
-			Msg("more monitors than cores so capping to core count")
-		fileMonitor.NumWorkers = uint(runtime.NumCPU())
-	}
-	if fileMonitor.MaxJobs == 0 {
-		fileMonitor.MaxJobs = defaultMaxJobs
-	}
+	fileMonitor.applyDefaults()
 	fileMonitor.ctxParent = parentCtx
 
 	fileMonitor.logger.Info().Msg("successfully initialized, starting up monitors")
-	fileMonitor.Start()
+	err = fileMonitor.Start()
+	if err != nil {
+		return nil, fmt.Errorf("unable to start fileMonitor: %w", err)
+	}
 	return &fileMonitor, nil
 }
 
+// Sets the number of workers and jobs if not configured
+func (f *FileMonitor) applyDefaults() {
+	if f.NumWorkers == 0 {
+		f.NumWorkers = uint(max(runtime.NumCPU()/2, 1))
+	} else if f.NumWorkers > uint(runtime.NumCPU()) {
+		f.logger.Warn().
+			Uint("NumWorkersOld", f.NumWorkers).
+			Int("NumWorkers", runtime.NumCPU()).
+			Msg("more monitors than cores so capping to core count")
+		f.NumWorkers = uint(runtime.NumCPU())
+	}
+	if f.MaxJobs == 0 {
+		f.MaxJobs = defaultMaxJobs
+	}
+}
+
 func (f *FileMonitor) AddDir(dir *Dir) error {
 	dir.parent = f
 
@@ -200,30 +372,52 @@ func (f *FileMonitor) AddDir(dir *Dir) error {
 		}
 	}
 
+	f.dirsLock.Lock()
 	f.Dirs[dir.Name] = dir
+	f.dirsLock.Unlock()
 	return f.Update()
 }
 
 func (f *FileMonitor) RemoveDir(dir *Dir) error {
-	if dir.Active {
-		dir.ctxCancel()
-	}
+	dir.Stop()
+	dir.closeCopiers()
+	f.dirsLock.Lock()
 	delete(f.Dirs, dir.Name)
+	f.dirsLock.Unlock()
 	f.logger.Info().Str("dir", dir.Name).Msg("remove directory successfully")
 	return f.Update()
 }
 
 func (f *FileMonitor) GetDirs() []string {
 	var dirs []string
-	for _, dir := range f.Dirs {
+	for _, dir := range f.dirList() {
 		dirs = append(dirs, dir.Name)
 	}
 	return dirs
 }
 
+// Returns the dir with the given name
+func (f *FileMonitor) GetDir(name string) (*Dir, bool) {
+	f.dirsLock.RLock()
+	defer f.dirsLock.RUnlock()
+	dir, ok := f.Dirs[name]
+	return dir, ok
+}
+
+// Dirs at the time of the call which can be used without holding the lock
+func (f *FileMonitor) dirList() []*Dir {
+	f.dirsLock.RLock()
+	defer f.dirsLock.RUnlock()
+	dirs := make([]*Dir, 0, len(f.Dirs))
+	for _, dir := range f.Dirs {
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
 func (f *FileMonitor) GetStats() *Stats {
 	var stats Stats
-	for _, dir := range f.Dirs {
+	for _, dir := range f.dirList() {
 		stats.Add(&dir.Stats)
 	}
 	return &stats
@@ -234,7 +428,7 @@ func (f *FileMonitor) GetStats() *Stats {
 // Likely caller will want to add Publisher, Copiers, and/or ErrorCopiers
 func (f *FileMonitor) NewDir(name, monitorFolder, publishLocation string, monitorFreq time.Duration, processor *Processor, overwriteExistingDir bool, matchGroups []MatchGroup) (*Dir, error) {
 	if !overwriteExistingDir {
-		existingDir, exists := f.Dirs[name]
+		existingDir, exists := f.GetDir(name)
 		if exists {
 			return existingDir, fmt.Errorf("dir with name already exists")
 		}
@@ -244,7 +438,6 @@ func (f *FileMonitor) NewDir(name, monitorFolder, publishLocation string, monito
 		Name:             name,
 		MonitorFolder:    monitorFolder,
 		MonitorFrequency: monitorFreq,
-		Active:           true,
 		Processor:        processor,
 		MatchGroups:      matchGroups,
 		Publishers:       make([]Publisher, 0),
@@ -253,7 +446,9 @@ func (f *FileMonitor) NewDir(name, monitorFolder, publishLocation string, monito
 		parent:           f,
 	}
 
+	f.dirsLock.Lock()
 	f.Dirs[name] = &dir
+	f.dirsLock.Unlock()
 	if f.Connected() {
 		err := dir.Monitor()
 		if err != nil {
@@ -264,7 +459,7 @@ func (f *FileMonitor) NewDir(name, monitorFolder, publishLocation string, monito
 }
 
 func (f *FileMonitor) AddAllDirPublisher(publisher Publisher) {
-	for _, dir := range f.Dirs {
-		dir.Publishers = append(dir.Publishers, publisher)
+	for _, dir := range f.dirList() {
+		dir.addPublisher(publisher)
 	}
 }
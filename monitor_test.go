@@ -0,0 +1,578 @@
+package fileMonitor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/treavorj/zerolog"
+	"github.com/treavorj/zerolog/log"
+	"github.com/treavorj/zerolog/pkgerrors"
+)
+
+func TestStop(t *testing.T) {
+	t.Parallel()
+
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+	logger := log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339Nano}).With().Caller().Logger()
+
+	monitorFolder := t.TempDir()
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(configFile, []byte(`{"NumWorkers": 1}`), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	fileMonitor, err := NewFileMonitor(context.Background(), logger, configFile)
+	if err != nil {
+		t.Fatalf("failed to create fileMonitor: %v", err)
+	} else if fileMonitor == nil {
+		t.Fatalf("fileMonitor is nil")
+	}
+
+	executor := &slowExecutor{delay: time.Millisecond * 200}
+	dir := &Dir{
+		Name:             t.Name(),
+		MonitorFolder:    monitorFolder,
+		MonitorFrequency: time.Millisecond * 50,
+		Processor:        &Processor{Executor: executor},
+	}
+	err = fileMonitor.AddDir(dir)
+	if err != nil {
+		t.Fatalf("failed to add dir: %v", err)
+	}
+
+	writeFiles := func(prefix string, count int) {
+		for n := range count {
+			err := os.WriteFile(filepath.Join(monitorFolder, fmt.Sprintf("%s%d.csv", prefix, n)), []byte("data"), os.ModePerm)
+			if err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+		}
+	}
+
+	// drains everything queued before the deadline
+	writeFiles("drained", 3)
+	time.Sleep(time.Millisecond * 150)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	unprocessed, err := fileMonitor.Stop(ctx)
+	cancel()
+	if err != nil {
+		t.Errorf("failed to stop: %v", err)
+	}
+	if len(unprocessed) != 0 {
+		t.Errorf("expected no unprocessed files but got %v", unprocessed)
+	}
+	if calls := executor.calls.Load(); calls != 3 {
+		t.Errorf("expected 3 files to be processed but got %d", calls)
+	}
+	if fileMonitor.Connected() || dir.IsActive() {
+		t.Errorf("expected fileMonitor and dir to be stopped")
+	}
+
+	// restarts and reports files not finished before the deadline
+	err = fileMonitor.Start()
+	if err != nil {
+		t.Fatalf("failed to restart: %v", err)
+	}
+	writeFiles("deadline", 3)
+	time.Sleep(time.Millisecond * 150)
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*100)
+	unprocessed, err = fileMonitor.Stop(ctx)
+	cancel()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline to be exceeded but got: %v", err)
+	}
+	if len(unprocessed[dir.Name]) == 0 {
+		t.Errorf("expected unprocessed files but got %v", unprocessed)
+	}
+
+	// dropped files are picked up again after restarting
+	time.Sleep(time.Millisecond * 300)
+	err = fileMonitor.Start()
+	if err != nil {
+		t.Fatalf("failed to restart: %v", err)
+	}
+	time.Sleep(time.Second)
+	files, err := os.ReadDir(monitorFolder)
+	if err != nil {
+		t.Fatalf("failed to read monitor folder: %v", err)
+	} else if len(files) != 0 {
+		t.Errorf("expected all files to be processed after restarting but %d remain", len(files))
+	}
+}
+
+func TestStopRetry(t *testing.T) {
+	t.Parallel()
+
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+	logger := log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339Nano}).With().Caller().Logger()
+
+	monitorFolder := t.TempDir()
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(configFile, []byte("{}"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	fileMonitor, err := NewFileMonitor(context.Background(), logger, configFile)
+	if err != nil {
+		t.Fatalf("failed to create fileMonitor: %v", err)
+	} else if fileMonitor == nil {
+		t.Fatalf("fileMonitor is nil")
+	}
+
+	publisher := &flakyPublish{failures: 100}
+	dir := &Dir{
+		Name:             t.Name(),
+		MonitorFolder:    monitorFolder,
+		MonitorFrequency: time.Millisecond * 50,
+		Processor:        &Processor{Executor: &slowExecutor{}},
+		Publishers:       []Publisher{publisher},
+		Retry:            RetryPolicy{MaxAttempts: 10, Backoff: time.Millisecond * 200},
+	}
+	err = fileMonitor.AddDir(dir)
+	if err != nil {
+		t.Fatalf("failed to add dir: %v", err)
+	}
+
+	filePath := filepath.Join(monitorFolder, "parked.csv")
+	err = os.WriteFile(filePath, []byte("data"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	time.Sleep(time.Millisecond * 150)
+
+	// parked files are reported without waiting for their backoff and their timers no longer fire
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	unprocessed, err := fileMonitor.Stop(ctx)
+	cancel()
+	if err != nil {
+		t.Errorf("failed to stop: %v", err)
+	}
+	if len(unprocessed[dir.Name]) != 1 {
+		t.Errorf("expected the parked file to be unprocessed but got %v", unprocessed)
+	}
+	time.Sleep(time.Millisecond * 400)
+	if calls := publisher.calls.Load(); calls != 1 {
+		t.Errorf("expected no retries after stopping but got %d publish calls", calls)
+	}
+	if dir.isInFlight(filePath) {
+		t.Errorf("expected the parked file to be released")
+	}
+
+	// picked up again after restarting
+	err = fileMonitor.Start()
+	if err != nil {
+		t.Fatalf("failed to restart: %v", err)
+	}
+	time.Sleep(time.Millisecond * 300)
+	if calls := publisher.calls.Load(); calls < 2 {
+		t.Errorf("expected the parked file to be processed again but got %d publish calls", calls)
+	}
+	_, _ = fileMonitor.Stop(context.Background())
+}
+
+func TestReload(t *testing.T) {
+	t.Parallel()
+
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+	logger := log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339Nano}).With().Caller().Logger()
+
+	monitorFolder := t.TempDir()
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	writeConfig := func(dirs string) {
+		config := fmt.Sprintf(`{"ReloadFrequency": %d, "Dirs": {%s}}`, time.Millisecond*50, dirs)
+		err := os.WriteFile(configFile, []byte(config), os.ModePerm)
+		if err != nil {
+			t.Fatalf("failed to write config file: %v", err)
+		}
+		time.Sleep(time.Millisecond * 300)
+	}
+	dirConfig := func(expression string) string {
+		return fmt.Sprintf(`"reloaded": {"Name": "reloaded", "MonitorFolder": %q, "MonitorFrequency": %d, "MatchGroups": [{"Expression": %q}]}`,
+			monitorFolder, time.Millisecond*50, expression)
+	}
+	writeFile := func(name string) string {
+		filePath := filepath.Join(monitorFolder, name)
+		err := os.WriteFile(filePath, []byte("data"), os.ModePerm)
+		if err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		time.Sleep(time.Millisecond * 300)
+		return filePath
+	}
+
+	writeConfig("")
+	fileMonitor, err := NewFileMonitor(context.Background(), logger, configFile)
+	if err != nil {
+		t.Fatalf("failed to create fileMonitor: %v", err)
+	} else if fileMonitor == nil {
+		t.Fatalf("fileMonitor is nil")
+	}
+
+	// added
+	writeConfig(dirConfig(`\.csv$`))
+	dir, ok := fileMonitor.GetDir("reloaded")
+	if !ok || !dir.IsActive() {
+		t.Fatalf("expected dir to be added and started")
+	}
+	csvFile := writeFile("first.csv")
+	if _, err := os.Stat(csvFile); !os.IsNotExist(err) {
+		t.Errorf("expected csv file to be processed")
+	}
+
+	// changed
+	writeConfig(dirConfig(`\.txt$`))
+	if replaced, _ := fileMonitor.GetDir("reloaded"); replaced == dir || dir.IsActive() {
+		t.Errorf("expected dir to be replaced")
+	}
+	dir, _ = fileMonitor.GetDir("reloaded")
+	csvFile = writeFile("second.csv")
+	if _, err := os.Stat(csvFile); err != nil {
+		t.Errorf("expected csv file to no longer match: %v", err)
+	}
+
+	// paused without being replaced
+	writeConfig(strings.Replace(dirConfig(`\.txt$`), `"MatchGroups"`, `"Paused": true, "MatchGroups"`, 1))
+	if current, _ := fileMonitor.GetDir("reloaded"); current != dir || !dir.IsPaused() {
+		t.Errorf("expected dir to be paused without being replaced")
+	}
+
+	// invalid
+	writeConfig(dirConfig(`(`))
+	if current, _ := fileMonitor.GetDir("reloaded"); current != dir || !dir.IsActive() {
+		t.Errorf("expected invalid config to keep the current dir")
+	}
+
+	// own writes are not reloaded
+	err = fileMonitor.Update()
+	if err != nil {
+		t.Fatalf("failed to update config: %v", err)
+	}
+	time.Sleep(time.Millisecond * 300)
+	if current, _ := fileMonitor.GetDir("reloaded"); current != dir {
+		t.Errorf("expected own write not to replace the dir")
+	}
+
+	// removed
+	writeConfig("")
+	if _, ok := fileMonitor.GetDir("reloaded"); ok || dir.IsActive() {
+		t.Errorf("expected dir to be removed and stopped")
+	}
+}
+
+func TestReloadRestart(t *testing.T) {
+	t.Parallel()
+
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+	logger := log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339Nano}).With().Caller().Logger()
+
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(configFile, []byte("{}"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	fileMonitor, err := NewFileMonitor(context.Background(), logger, configFile)
+	if err != nil {
+		t.Fatalf("failed to create fileMonitor: %v", err)
+	} else if fileMonitor == nil {
+		t.Fatalf("fileMonitor is nil")
+	}
+	defer fileMonitor.Stop(context.Background())
+
+	err = os.WriteFile(configFile, []byte(`{"MaxJobs": 7, "ConfigBackups": 2}`), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	err = fileMonitor.Reload()
+	if !errors.Is(err, ErrRestartRequired) || !strings.Contains(err.Error(), "MaxJobs") {
+		t.Errorf("expected MaxJobs to require a restart but got: %v", err)
+	}
+	if fileMonitor.ConfigBackups != 2 {
+		t.Errorf("expected ConfigBackups to be applied but got %d", fileMonitor.ConfigBackups)
+	}
+	if fileMonitor.MaxJobs != 7 {
+		t.Errorf("expected MaxJobs to be kept for saving but got %d", fileMonitor.MaxJobs)
+	}
+}
+
+func TestReloadUnreachable(t *testing.T) {
+	t.Parallel()
+
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+	logger := log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339Nano}).With().Caller().Logger()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	server := listener.Addr().String()
+	listener.Close()
+
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	err = os.WriteFile(configFile, []byte("{}"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	fileMonitor, err := NewFileMonitor(context.Background(), logger, configFile)
+	if err != nil {
+		t.Fatalf("failed to create fileMonitor: %v", err)
+	} else if fileMonitor == nil {
+		t.Fatalf("fileMonitor is nil")
+	}
+	defer fileMonitor.Stop(context.Background())
+
+	config := fmt.Sprintf(`{"Dirs": {"unreachable": {"Name": "unreachable", "MonitorFolder": %q, "MonitorFrequency": %d, "Copiers": [{"Type": "ftp", "Server": %q}]}}}`,
+		t.TempDir(), time.Millisecond*50, server)
+	err = os.WriteFile(configFile, []byte(config), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	err = fileMonitor.Reload()
+	if err != nil {
+		t.Errorf("expected an unreachable copier not to fail the reload but got: %v", err)
+	}
+	if _, ok := fileMonitor.GetDir("unreachable"); !ok {
+		t.Errorf("expected dir with an unreachable copier to be applied")
+	}
+
+	// explicit validation still connects
+	errs, err := ValidateConfigFile(configFile)
+	if err != nil {
+		t.Fatalf("failed to validate config file: %v", err)
+	} else if len(errs) != 1 || errs[0].Field != "Copiers[0]" {
+		t.Errorf("expected the unreachable copier to be reported but got: %v", errs)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	notFolder := filepath.Join(t.TempDir(), "file")
+	err := os.WriteFile(notFolder, []byte("data"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	fileMonitor := &FileMonitor{Dirs: map[string]*Dir{
+		"valid": {
+			Name:             "valid",
+			MonitorFolder:    t.TempDir(),
+			MonitorFrequency: time.Second,
+			MatchGroups:      []MatchGroup{{Expression: `\.csv$`}},
+			Copiers:          []Copier{&CopierLocal{Destination: t.TempDir()}},
+		},
+		"invalid": {
+			Name:          "other",
+			MonitorFolder: filepath.Join(t.TempDir(), "missing"),
+			MatchGroups:   []MatchGroup{{Expression: `\.csv$`}, {Expression: `(`}},
+			OnSuccess:     Disposition{Action: DispositionArchive},
+			Copiers:       []Copier{&CopierLocal{Destination: filepath.Join(notFolder, "destination")}},
+		},
+	}}
+
+	errs := fileMonitor.Validate()
+	fields := make(map[string]bool)
+	for _, err := range errs {
+		if err.Dir == "valid" {
+			t.Errorf("expected no problems with the valid dir but got: %v", err)
+		}
+		fields[err.Field] = true
+	}
+	for _, field := range []string{"Dirs.invalid", "MonitorFrequency", "MonitorFolder", "MatchGroups[1].Expression", "OnSuccess.ArchiveFolder", "Copiers[0]"} {
+		if !fields[field] {
+			t.Errorf("expected a problem with %s but got: %v", field, errs)
+		}
+	}
+
+	delete(fileMonitor.Dirs, "invalid")
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	data, err := json.Marshal(fileMonitor)
+	if err != nil {
+		t.Fatalf("failed to marshal config: %v", err)
+	}
+	err = os.WriteFile(configFile, data, os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	errs, err = ValidateConfigFile(configFile)
+	if err != nil {
+		t.Errorf("failed to validate config file: %v", err)
+	} else if len(errs) != 0 {
+		t.Errorf("expected no problems but got: %v", errs)
+	}
+}
+
+func TestConfigFormats(t *testing.T) {
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+	logger := log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339Nano}).With().Caller().Logger()
+
+	configs := map[string]string{
+		"config.yaml": `
+Dirs:
+  formats:
+    Name: formats
+    MonitorFolder: ${TEST_CONFIG_FORMATS}/monitor
+    MonitorFrequency: 50ms
+    Retry:
+      Backoff: 1m30s
+    Copiers:
+      - Type: 1
+        Destination: ${TEST_CONFIG_FORMATS}/copied
+`,
+		"config.toml": `
+[Dirs.formats]
+Name = "formats"
+MonitorFolder = "${TEST_CONFIG_FORMATS}/monitor"
+MonitorFrequency = "50ms"
+
+[Dirs.formats.Retry]
+Backoff = "1m30s"
+
+[[Dirs.formats.Copiers]]
+Type = 1
+Destination = "${TEST_CONFIG_FORMATS}/copied"
+`,
+	}
+
+	for name, config := range configs {
+		t.Run(name, func(t *testing.T) {
+			folder := t.TempDir()
+			t.Setenv("TEST_CONFIG_FORMATS", folder)
+			err := os.Mkdir(filepath.Join(folder, "monitor"), os.ModePerm)
+			if err != nil {
+				t.Fatalf("failed to create monitor folder: %v", err)
+			}
+			configFile := filepath.Join(t.TempDir(), name)
+			err = os.WriteFile(configFile, []byte(config), os.ModePerm)
+			if err != nil {
+				t.Fatalf("failed to write config file: %v", err)
+			}
+
+			fileMonitor, err := NewFileMonitor(context.Background(), logger, configFile)
+			if err != nil {
+				t.Fatalf("failed to create fileMonitor: %v", err)
+			} else if fileMonitor == nil {
+				t.Fatalf("fileMonitor is nil")
+			}
+			defer fileMonitor.Stop(context.Background())
+
+			dir, _ := fileMonitor.GetDir("formats")
+			if dir == nil || dir.MonitorFrequency != time.Millisecond*50 || dir.Retry.Backoff != time.Second*90 || len(dir.Copiers) != 1 {
+				t.Fatalf("config not loaded correctly: %+v", dir)
+			}
+
+			err = os.WriteFile(filepath.Join(folder, "monitor", "file.csv"), []byte("data"), os.ModePerm)
+			if err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+			time.Sleep(time.Millisecond * 300)
+			_, err = os.Stat(filepath.Join(folder, "copied", "file.csv"))
+			if err != nil {
+				t.Errorf("expected file to be copied to the expanded destination: %v", err)
+			}
+
+			// saved in the same format keeping the references and human durations
+			err = fileMonitor.Update()
+			if err != nil {
+				t.Fatalf("failed to update config: %v", err)
+			}
+			var saved FileMonitor
+			data, err := os.ReadFile(configFile)
+			if err != nil {
+				t.Fatalf("failed to read config file: %v", err)
+			}
+			err = configFormatOf(configFile).unmarshal(data, &saved)
+			if err != nil {
+				t.Fatalf("failed to unmarshal saved config: %v\n%s", err, data)
+			}
+			if !strings.Contains(string(data), "50ms") || saved.Dirs["formats"].MonitorFolder != "${TEST_CONFIG_FORMATS}/monitor" {
+				t.Errorf("unexpected saved config:\n%s", data)
+			}
+		})
+	}
+}
+
+func TestConfigFile(t *testing.T) {
+	t.Parallel()
+
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+	logger := log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339Nano}).With().Caller().Logger()
+
+	configFolder := t.TempDir()
+	configFile := filepath.Join(configFolder, "config.json")
+	err := os.WriteFile(configFile, []byte(`{"ConfigBackups": 2}`), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	fileMonitor, err := NewFileMonitor(context.Background(), logger, configFile)
+	if err != nil {
+		t.Fatalf("failed to create fileMonitor: %v", err)
+	} else if fileMonitor == nil {
+		t.Fatalf("fileMonitor is nil")
+	}
+	defer fileMonitor.Stop(context.Background())
+	if fileMonitor.Version != ConfigVersion {
+		t.Errorf("expected config to be migrated to version %d but got %d", ConfigVersion, fileMonitor.Version)
+	}
+
+	for n := range 3 {
+		fileMonitor.MaxJobs = uint(n + 1)
+		err = fileMonitor.Update()
+		if err != nil {
+			t.Fatalf("failed to update config: %v", err)
+		}
+	}
+
+	fileStats, err := os.Stat(configFile)
+	if err != nil {
+		t.Fatalf("failed to stat config file: %v", err)
+	} else if runtime.GOOS != "windows" && fileStats.Mode().Perm() != 0600 {
+		t.Errorf("expected config file permissions 0600 but got %v", fileStats.Mode().Perm())
+	}
+
+	files, err := os.ReadDir(configFolder)
+	if err != nil {
+		t.Fatalf("failed to read config folder: %v", err)
+	}
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	if strings.Join(names, ",") != "config.1.json,config.2.json,config.json" {
+		t.Errorf("expected the config and 2 backups but got: %v", names)
+	}
+	backup, err := os.ReadFile(filepath.Join(configFolder, "config.1.json"))
+	if err != nil {
+		t.Fatalf("failed to read backup: %v", err)
+	} else if !strings.Contains(string(backup), `"MaxJobs":2`) || !strings.Contains(string(backup), fmt.Sprintf(`"Version":%d`, ConfigVersion)) {
+		t.Errorf("expected the newest backup to hold the previous config but got: %s", backup)
+	}
+
+	newerFile := filepath.Join(configFolder, "newer.json")
+	err = os.WriteFile(newerFile, []byte(`{"Version": 99}`), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	_, err = NewFileMonitor(context.Background(), logger, newerFile)
+	if err == nil {
+		t.Errorf("expected a config from a newer version to fail to load")
+	}
+}
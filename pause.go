@@ -0,0 +1,50 @@
+package fileMonitor
+
+import "fmt"
+
+// Stops scheduling new files until resumed. Files already queued or being processed are still finished
+func (d *Dir) Pause() {
+	d.pauseLock.Lock()
+	d.Paused = true
+	d.pauseLock.Unlock()
+	d.log.Info().Msg("paused dir")
+}
+
+// Resumes scheduling files and rescans the folder for files which arrived while paused
+func (d *Dir) Resume() {
+	d.pauseLock.Lock()
+	d.Paused = false
+	d.pauseLock.Unlock()
+	d.log.Info().Msg("resumed dir")
+
+	if d.ctx != nil && d.ctx.Err() == nil {
+		go d.Rescan()
+	}
+}
+
+// Checks if scheduling new files is paused
+func (d *Dir) IsPaused() bool {
+	d.pauseLock.Lock()
+	defer d.pauseLock.Unlock()
+	return d.Paused
+}
+
+// Pauses the dir with the given name and saves the paused state to the config
+func (f *FileMonitor) Pause(name string) error {
+	dir, ok := f.GetDir(name)
+	if !ok {
+		return fmt.Errorf("no dir with name: %s", name)
+	}
+	dir.Pause()
+	return f.Update()
+}
+
+// Resumes the dir with the given name and saves the state to the config
+func (f *FileMonitor) Resume(name string) error {
+	dir, ok := f.GetDir(name)
+	if !ok {
+		return fmt.Errorf("no dir with name: %s", name)
+	}
+	dir.Resume()
+	return f.Update()
+}
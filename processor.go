@@ -3,8 +3,6 @@ package fileMonitor
 import (
 	"encoding/json"
 	"fmt"
-
-	"github.com/treavorj/go-csvParse"
 )
 
 type Processor struct {
@@ -36,21 +34,31 @@ type ProcessorExecutor interface {
 	Process(filepath string) (result [][]byte, id []string, err error)
 }
 
-type ProcessorType int
+// Name a processor type is registered with
+type ProcessorType string
 
 const (
-	ProcessorTypeNull ProcessorType = iota
-	ProcessorTypeCsv
+	ProcessorTypeNull ProcessorType = ""
+	ProcessorTypeCsv  ProcessorType = "csv"
 )
 
+// Indexed by the integer types used by older configs
+var legacyProcessorTypes = []string{string(ProcessorTypeNull), string(ProcessorTypeCsv)}
+
+func (p *ProcessorType) UnmarshalJSON(data []byte) error {
+	name, err := unmarshalTypeName(data, legacyProcessorTypes)
+	*p = ProcessorType(name)
+	return err
+}
+
 func (p ProcessorType) unmarshalType(data []byte) (ProcessorExecutor, error) {
-	switch p {
-	case ProcessorTypeNull:
+	if p == ProcessorTypeNull {
 		return nil, nil
-	case ProcessorTypeCsv:
-		processor := &csvParse.Csv{}
-		return processor, json.Unmarshal(data, processor)
-	default:
-		return nil, fmt.Errorf("no valid processor for %v", p)
 	}
+
+	processor, err := newProcessor(p)
+	if err != nil {
+		return nil, err
+	}
+	return processor, json.Unmarshal(data, processor)
 }
@@ -0,0 +1,205 @@
+package fileMonitor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Suffix of the sidecar written next to quarantined files
+const quarantineSidecarSuffix = ".error.json"
+
+type FailureStage string
+
+const (
+	FailureStageMatch   FailureStage = "match"
+	FailureStageStat    FailureStage = "stat"
+	FailureStageLedger  FailureStage = "ledger"
+	FailureStageProcess FailureStage = "process"
+	FailureStagePublish FailureStage = "publish"
+	FailureStageCopy    FailureStage = "copy"
+)
+
+// Describes why a file failed. Written as a JSON sidecar next to quarantined files
+type Failure struct {
+	Dir      string
+	File     string // original path of the file
+	Stage    FailureStage
+	Index    int      // index of the publisher or copier that failed, otherwise -1
+	Errors   []string // error chain from outermost to innermost
+	Worker   uint
+	Attempt  uint
+	Started  time.Time
+	Failed   time.Time
+	Duration string
+}
+
+func newFailure(d *Dir, task *fileTask, stage FailureStage, index int, err error) *Failure {
+	now := time.Now()
+	return &Failure{
+		Dir:      d.Name,
+		File:     task.originalPath(),
+		Stage:    stage,
+		Index:    index,
+		Errors:   errorChain(err),
+		Worker:   task.worker,
+		Attempt:  task.attempt,
+		Started:  task.started,
+		Failed:   now,
+		Duration: now.Sub(task.started).String(),
+	}
+}
+
+// Flattens the wrapped and joined errors into their messages
+func errorChain(err error) []string {
+	var chain []string
+	for err != nil {
+		chain = append(chain, err.Error())
+
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, inner := range joined.Unwrap() {
+				chain = append(chain, errorChain(inner)...)
+			}
+			return chain
+		}
+		err = errors.Unwrap(err)
+	}
+	return chain
+}
+
+// QuarantineFolder with environment variables expanded
+func (d *Dir) quarantineFolder() string {
+	return expandEnv(d.QuarantineFolder)
+}
+
+// Checks if path is the QuarantineFolder so quarantined files and their sidecars within the MonitorFolder are not
+// processed again
+func (d *Dir) isQuarantineFolder(path string) bool {
+	return d.QuarantineFolder != "" && filepath.Clean(path) == filepath.Clean(d.quarantineFolder())
+}
+
+// Moves the failed file into the QuarantineFolder keeping its path relative to the MonitorFolder and writes the sidecar
+func (d *Dir) quarantine(filePath, monitorFolder string, failure *Failure) error {
+	err := moveFile(filePath, monitorFolder, d.quarantineFolder())
+	if err != nil {
+		return fmt.Errorf("unable to move file into quarantine: %w", err)
+	}
+
+	data, err := json.MarshalIndent(failure, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to marshal failure: %w", err)
+	}
+
+	sidecarPath := getOutFileName(filePath, monitorFolder, d.quarantineFolder()) + quarantineSidecarSuffix
+	err = os.WriteFile(sidecarPath, data, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to write sidecar: %w", err)
+	}
+	return nil
+}
+
+// Quarantines a file which failed before it was scheduled
+func (d *Dir) quarantineUnscheduled(dir, name string, stage FailureStage, err error) {
+	if d.QuarantineFolder == "" {
+		return
+	}
+
+	task := &fileTask{dir: dir, name: name, started: time.Now()}
+	failure := newFailure(d, task, stage, -1, err)
+	err = d.quarantine(task.originalPath(), d.monitorFolder(), failure)
+	if err != nil {
+		d.log.Error().Err(err).Str("filename", name).Str("dir", dir).Msg("failed to quarantine file")
+	}
+}
+
+// Lists the failures of all files in the QuarantineFolder
+func (d *Dir) Quarantined() ([]Failure, error) {
+	if d.QuarantineFolder == "" {
+		return nil, fmt.Errorf("no quarantine folder configured")
+	}
+
+	var failures []Failure
+	err := filepath.WalkDir(d.quarantineFolder(), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !strings.HasSuffix(path, quarantineSidecarSuffix) {
+			return err
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("unable to read sidecar: %w", err)
+		}
+		var failure Failure
+		err = json.Unmarshal(data, &failure)
+		if err != nil {
+			return fmt.Errorf("unable to unmarshal sidecar %s: %w", path, err)
+		}
+		failures = append(failures, failure)
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return failures, err
+}
+
+// Moves quarantined files back into the MonitorFolder so they are processed again and removes their sidecars
+//
+// Paths are relative to the QuarantineFolder and must stay within it. If none are provided all quarantined files are
+// requeued
+func (d *Dir) Requeue(relPaths ...string) (int, error) {
+	if d.QuarantineFolder == "" {
+		return 0, fmt.Errorf("no quarantine folder configured")
+	}
+
+	if len(relPaths) == 0 {
+		err := filepath.WalkDir(d.quarantineFolder(), func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() || strings.HasSuffix(path, quarantineSidecarSuffix) {
+				return err
+			}
+			relPath, err := filepath.Rel(d.quarantineFolder(), path)
+			relPaths = append(relPaths, relPath)
+			return err
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return 0, fmt.Errorf("unable to list quarantined files: %w", err)
+		}
+	}
+
+	var errs []error
+	requeued := 0
+	for _, relPath := range relPaths {
+		if !filepath.IsLocal(relPath) {
+			errs = append(errs, fmt.Errorf("unable to requeue %s: path is not within the quarantine folder", relPath))
+			continue
+		}
+		filePath := filepath.Join(d.quarantineFolder(), relPath)
+		err := moveFile(filePath, d.quarantineFolder(), d.monitorFolder())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("unable to requeue %s: %w", relPath, err))
+			continue
+		}
+		requeued++
+
+		err = os.Remove(filePath + quarantineSidecarSuffix)
+		if err != nil && !os.IsNotExist(err) {
+			errs = append(errs, fmt.Errorf("unable to remove sidecar of %s: %w", relPath, err))
+		}
+	}
+
+	d.log.Info().Int("requeued", requeued).Msg("requeued quarantined files")
+	return requeued, errors.Join(errs...)
+}
+
+// Requeues quarantined files of the dir with the given name
+func (f *FileMonitor) Requeue(name string, relPaths ...string) (int, error) {
+	dir, ok := f.GetDir(name)
+	if !ok {
+		return 0, fmt.Errorf("no dir with name: %s", name)
+	}
+	return dir.Requeue(relPaths...)
+}
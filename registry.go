@@ -0,0 +1,191 @@
+package fileMonitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+
+	"github.com/treavorj/go-csvParse"
+)
+
+// Creates an empty value of a registered type which its config is decoded into
+type (
+	CopierFactory    func() Copier
+	ProcessorFactory func() ProcessorExecutor
+	PublisherFactory func() Publisher
+)
+
+// Named factories used to load Copiers, Processors and Publishers from the config
+var registry = struct {
+	lock           sync.RWMutex
+	copiers        map[CopierType]CopierFactory
+	processors     map[ProcessorType]ProcessorFactory
+	publishers     map[string]PublisherFactory
+	publisherNames map[reflect.Type]string
+	sources        map[string]SourceFactory // keyed by URL scheme
+}{
+	copiers:        make(map[CopierType]CopierFactory),
+	processors:     make(map[ProcessorType]ProcessorFactory),
+	publishers:     make(map[string]PublisherFactory),
+	publisherNames: make(map[reflect.Type]string),
+	sources:        make(map[string]SourceFactory),
+}
+
+func init() {
+	RegisterCopier(CopierTypeLocal, func() Copier { return &CopierLocal{} })
+	RegisterCopier(CopierTypeFTP, func() Copier { return &CopierFtp{} })
+	RegisterCopier(CopierTypeSmb, func() Copier { return &CopierSmb{} })
+	RegisterCopier(CopierTypeSftp, func() Copier { return &CopierSftp{} })
+	RegisterCopier(CopierTypeS3, func() Copier { return &CopierS3{} })
+	RegisterProcessor(ProcessorTypeCsv, func() ProcessorExecutor { return &csvParse.Csv{} })
+	RegisterSource("ftp", openSourceFtp)
+	RegisterSource("smb", openSourceSmb)
+	RegisterSource("sftp", openSourceSftp)
+	RegisterSource("s3", openSourceS3)
+}
+
+// Registers a copier type so it can be loaded from the config. The copier's GetType must return the same name
+// and its MarshalJSON must include it as "Type"
+func RegisterCopier(name CopierType, factory CopierFactory) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	registry.copiers[name] = factory
+}
+
+// Registers a processor type so it can be loaded from the config as the Executor of a Processor with the Type name
+func RegisterProcessor(name ProcessorType, factory ProcessorFactory) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	registry.processors[name] = factory
+}
+
+// Registers a publisher type so it can be loaded from and saved to the config of a Dir
+//
+// The name is stored as "Type" next to the fields of the publisher. Publishers of types that are not registered
+// are not saved and must be attached in code
+func RegisterPublisher(name string, factory PublisherFactory) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	registry.publishers[name] = factory
+	registry.publisherNames[reflect.TypeOf(factory())] = name
+}
+
+func newCopier(name CopierType) (Copier, error) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	factory, ok := registry.copiers[name]
+	if !ok {
+		return nil, fmt.Errorf("no copier registered with type: %s", name)
+	}
+	return factory(), nil
+}
+
+func newProcessor(name ProcessorType) (ProcessorExecutor, error) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	factory, ok := registry.processors[name]
+	if !ok {
+		return nil, fmt.Errorf("no processor registered with type: %s", name)
+	}
+	return factory(), nil
+}
+
+func newPublisher(name string) (Publisher, error) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	factory, ok := registry.publishers[name]
+	if !ok {
+		return nil, fmt.Errorf("no publisher registered with type: %s", name)
+	}
+	return factory(), nil
+}
+
+// Name the publisher's type was registered with
+func publisherName(publisher Publisher) (string, bool) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	name, ok := registry.publisherNames[reflect.TypeOf(publisher)]
+	return name, ok
+}
+
+// Decodes a type name which older configs stored as the index into legacy
+func unmarshalTypeName(data []byte, legacy []string) (string, error) {
+	var name string
+	err := json.Unmarshal(data, &name)
+	if err == nil {
+		return name, nil
+	}
+
+	index, err := strconv.Atoi(string(data))
+	if err != nil {
+		return "", fmt.Errorf("type must be a name: %s", data)
+	} else if index < 0 || index >= len(legacy) {
+		return "", fmt.Errorf("invalid legacy type: %d", index)
+	}
+	return legacy[index], nil
+}
+
+// Publisher with its registered type name used to load it from the config
+type PublisherAlias struct {
+	Type    string
+	Details json.RawMessage
+}
+
+func (p *PublisherAlias) UnmarshalJSON(data []byte) error {
+	var aux struct {
+		Type string
+	}
+	err := json.Unmarshal(data, &aux)
+	if err != nil {
+		return err
+	} else if aux.Type == "" {
+		return fmt.Errorf("missing Type field in Publisher")
+	}
+
+	p.Type = aux.Type
+	p.Details = data
+	return nil
+}
+
+func (p *PublisherAlias) GetPublisher() (Publisher, error) {
+	publisher, err := newPublisher(p.Type)
+	if err != nil {
+		return nil, err
+	}
+	return publisher, json.Unmarshal(p.Details, publisher)
+}
+
+// Encodes the publishers of registered types with their type name. Others are skipped
+func marshalPublishers(publishers []Publisher) ([]json.RawMessage, error) {
+	encoded := make([]json.RawMessage, 0, len(publishers))
+	for _, publisher := range publishers {
+		name, ok := publisherName(publisher)
+		if !ok {
+			continue
+		}
+
+		data, err := json.Marshal(publisher)
+		if err != nil {
+			return nil, fmt.Errorf("unable to marshal publisher %s: %w", name, err)
+		}
+		var fields map[string]json.RawMessage
+		err = json.Unmarshal(data, &fields)
+		if err != nil {
+			return nil, fmt.Errorf("publisher %s must marshal to an object: %w", name, err)
+		}
+		fields["Type"], _ = json.Marshal(name)
+
+		data, err = json.Marshal(fields)
+		if err != nil {
+			return nil, fmt.Errorf("unable to marshal publisher %s: %w", name, err)
+		}
+		encoded = append(encoded, data)
+	}
+	return encoded, nil
+}
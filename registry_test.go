@@ -0,0 +1,101 @@
+package fileMonitor
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+type memoryCopier struct {
+	Name string
+}
+
+func (c *memoryCopier) Copy(filePath, monitorDir string) error {
+	return nil
+}
+
+func (c *memoryCopier) GetType() CopierType {
+	return "memory"
+}
+
+func (c *memoryCopier) MarshalJSON() ([]byte, error) {
+	type Alias memoryCopier
+	return json.Marshal(&struct {
+		Type CopierType `json:"Type"`
+		*Alias
+	}{
+		Type:  c.GetType(),
+		Alias: (*Alias)(c),
+	})
+}
+
+type memoryPublisher struct {
+	Topic string
+}
+
+func (p *memoryPublisher) Publish(dir *Dir, result [][]byte, id []string) error {
+	return nil
+}
+
+func TestRegistry(t *testing.T) {
+	t.Parallel()
+
+	RegisterCopier("memory", func() Copier { return &memoryCopier{} })
+	RegisterPublisher("memory", func() Publisher { return &memoryPublisher{} })
+
+	config := `{
+		"Name": "registry",
+		"Copiers": [{"Type": 1, "Destination": "legacy"}, {"Type": "memory", "Name": "custom"}],
+		"ErrorCopiers": [{"Type": "local", "Destination": "named"}],
+		"Publishers": [{"Type": "memory", "Topic": "results"}],
+		"Processor": {"Type": 0}
+	}`
+	var dir Dir
+	err := json.Unmarshal([]byte(config), &dir)
+	if err != nil {
+		t.Fatalf("failed to unmarshal dir: %v", err)
+	}
+
+	if copier, ok := dir.Copiers[0].(*CopierLocal); !ok || copier.Destination != "legacy" {
+		t.Errorf("expected legacy integer type to load a local copier but got %#v", dir.Copiers[0])
+	}
+	if copier, ok := dir.Copiers[1].(*memoryCopier); !ok || copier.Name != "custom" {
+		t.Errorf("expected registered copier but got %#v", dir.Copiers[1])
+	}
+	if copier, ok := dir.ErrorCopiers[0].(*CopierLocal); !ok || copier.Destination != "named" {
+		t.Errorf("expected named type to load a local copier but got %#v", dir.ErrorCopiers[0])
+	}
+	if len(dir.Publishers) != 1 {
+		t.Fatalf("expected 1 publisher but got %d", len(dir.Publishers))
+	} else if publisher, ok := dir.Publishers[0].(*memoryPublisher); !ok || publisher.Topic != "results" {
+		t.Errorf("expected registered publisher but got %#v", dir.Publishers[0])
+	}
+	if dir.Processor == nil || dir.Processor.Executor != nil {
+		t.Errorf("expected legacy null processor but got %#v", dir.Processor)
+	}
+
+	// publishers attached in code are not saved
+	dir.Publishers = append(dir.Publishers, &countPublish{})
+	data, err := json.Marshal(&dir)
+	if err != nil {
+		t.Fatalf("failed to marshal dir: %v", err)
+	}
+	for _, expected := range []string{`"Type":"local"`, `"Type":"memory","Name":"custom"`, `"Publishers":[{"Topic":"results","Type":"memory"}]`} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected %s in marshaled dir: %s", expected, data)
+		}
+	}
+
+	var reloaded Dir
+	err = json.Unmarshal(data, &reloaded)
+	if err != nil {
+		t.Fatalf("failed to unmarshal marshaled dir: %v", err)
+	} else if len(reloaded.Copiers) != 2 || len(reloaded.Publishers) != 1 {
+		t.Errorf("expected copiers and publishers to round trip: %s", data)
+	}
+
+	err = json.Unmarshal([]byte(`{"Copiers": [{"Type": "unknown"}]}`), &reloaded)
+	if err == nil {
+		t.Errorf("expected unregistered copier type to fail")
+	}
+}
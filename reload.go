@@ -0,0 +1,202 @@
+package fileMonitor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+)
+
+// Reported by Reload for settings of the FileMonitor which are saved but only used once the process restarts
+var ErrRestartRequired = errors.New("only applied after a restart")
+
+// Polls the config file and reloads the Dirs when it changes until ctx is done
+//
+// Stops polling if a reload sets ReloadFrequency to 0
+func (f *FileMonitor) watchConfig(ctx context.Context) {
+	frequency := f.reloadFrequency()
+	if frequency <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(frequency)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := f.Reload()
+			if errors.Is(err, ErrRestartRequired) {
+				f.logger.Warn().Err(err).Msg("reloaded config but some changes need a restart")
+			} else if err != nil {
+				f.logger.Error().Err(err).Msg("failed to reload config. Keeping the current config")
+			}
+
+			if changed := f.reloadFrequency(); changed <= 0 {
+				return
+			} else if changed != frequency {
+				frequency = changed
+				ticker.Reset(frequency)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (f *FileMonitor) reloadFrequency() time.Duration {
+	f.configLock.Lock()
+	defer f.configLock.Unlock()
+	return f.ReloadFrequency
+}
+
+// Reads the config file and applies changes to the Dirs if it changed since it was last read or written
+//
+// Added dirs are started, removed dirs are stopped and changed dirs are replaced. Files already queued or being
+// processed by a replaced dir finish with its previous config. If the new config is invalid the current one is kept
+// until the file changes again
+//
+// ReloadFrequency and ConfigBackups are applied directly. Changes to MaxJobs, NumWorkers and LedgerPath are kept so
+// they are saved with the config but are only used after a restart and reported with ErrRestartRequired
+//
+// Only the settings are checked before applying the config. Remote sources and copier destinations of added and changed
+// dirs are checked once applied and logged as warnings so an unreachable server does not hold up the reload
+func (f *FileMonitor) Reload() error {
+	applied, err := f.reload()
+	for _, dir := range applied {
+		errs := dir.validate(true)
+		if len(errs) > 0 {
+			f.logger.Warn().Err(errs).Str("dir", dir.Name).Msg("applied dir on reload but it is not reachable")
+		}
+	}
+	return err
+}
+
+// Applies the config file and returns the dirs which were added or changed
+func (f *FileMonitor) reload() ([]*Dir, error) {
+	f.configLock.Lock()
+	defer f.configLock.Unlock()
+
+	data, err := os.ReadFile(f.configPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config file: %w", err)
+	}
+	hash := sha256.Sum256(data)
+	if hash == f.configHash {
+		return nil, nil
+	}
+	// a rejected config is only reported again once the file changes
+	f.configHash = hash
+
+	loaded := FileMonitor{secrets: f.secrets}
+	err = configFormatOf(f.configPath).unmarshal(data, &loaded)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unmarshal config file: %w", err)
+	}
+	errs := loaded.validate(false)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid config: %w", errs)
+	}
+	loaded.logger = f.logger
+	loaded.applyDefaults()
+	f.ReloadFrequency = loaded.ReloadFrequency
+	f.ConfigBackups = loaded.ConfigBackups
+
+	var restart ValidationErrors
+	needsRestart := func(field string, current, changed any) {
+		if current != changed {
+			restart = append(restart, &ValidationError{Field: field, Err: fmt.Errorf("changed from %v to %v: %w", current, changed, ErrRestartRequired)})
+		}
+	}
+	needsRestart("MaxJobs", f.MaxJobs, loaded.MaxJobs)
+	needsRestart("NumWorkers", f.NumWorkers, loaded.NumWorkers)
+	needsRestart("LedgerPath", f.LedgerPath, loaded.LedgerPath)
+	f.MaxJobs, f.NumWorkers, f.LedgerPath = loaded.MaxJobs, loaded.NumWorkers, loaded.LedgerPath
+
+	f.dirsLock.Lock()
+	defer f.dirsLock.Unlock()
+	for name, dir := range f.Dirs {
+		if _, ok := loaded.Dirs[name]; !ok {
+			dir.Stop()
+			dir.closeCopiers()
+			delete(f.Dirs, name)
+			f.logger.Info().Str("dir", name).Msg("removed dir on reload")
+		}
+	}
+
+	var applied []*Dir
+	for name, dir := range loaded.Dirs {
+		dir.parent = f
+		existing, ok := f.Dirs[name]
+		if ok {
+			if existing.sameConfig(dir) {
+				if dir.Paused && !existing.IsPaused() {
+					existing.Pause()
+				} else if !dir.Paused && existing.IsPaused() {
+					existing.Resume()
+				}
+				continue
+			}
+			existing.Stop()
+			existing.closeCopiers()
+			dir.handover(existing)
+		}
+
+		f.Dirs[name] = dir
+		if f.Connected() {
+			err = dir.Monitor()
+			if err != nil {
+				f.logger.Error().Err(err).Str("dir", name).Msg("failed to start dir on reload")
+			}
+		}
+		applied = append(applied, dir)
+		f.logger.Info().Str("dir", name).Bool("replaced", ok).Msg("applied dir on reload")
+	}
+
+	if len(restart) > 0 {
+		return applied, restart
+	}
+	return applied, nil
+}
+
+// Checks if the other dir has the same configuration ignoring runtime state
+func (d *Dir) sameConfig(other *Dir) bool {
+	config := func(dir *Dir) map[string]any {
+		data, err := json.Marshal(dir)
+		if err != nil {
+			return nil
+		}
+		var config map[string]any
+		if json.Unmarshal(data, &config) != nil {
+			return nil
+		}
+		delete(config, "Stats")
+		delete(config, "Active")
+		delete(config, "Paused") // applied to the running dir without replacing it
+		return config
+	}
+
+	current := config(d)
+	return current != nil && reflect.DeepEqual(current, config(other))
+}
+
+// Takes over the runtime state of the dir being replaced which is not part of the config
+func (d *Dir) handover(previous *Dir) {
+	d.inFlight = previous.inFlight
+	d.Watcher = previous.Watcher
+
+	// publishers attached in code are not part of the config
+	for _, publisher := range previous.publishers() {
+		if _, ok := publisherName(publisher); !ok {
+			d.addPublisher(publisher)
+		}
+	}
+
+	previous.leftLock.Lock()
+	d.left = previous.left
+	previous.leftLock.Unlock()
+}
@@ -0,0 +1,162 @@
+package fileMonitor
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/textproto"
+	"os"
+	"regexp"
+	"syscall"
+	"time"
+)
+
+// Policy for reattempting files whose publish or copy stage failed with a retryable error
+//
+// Only the stages which have not completed are attempted again. Files are sent to the ErrorCopiers once
+// the attempts are exhausted or the error is not retryable. The zero value disables retries
+type RetryPolicy struct {
+	MaxAttempts     uint          // Total attempts including the first
+	Backoff         time.Duration // Delay before the first retry which doubles for each following attempt
+	MaxBackoff      time.Duration // Upper bound of the delay. 0 for no limit
+	Jitter          float64       // Fraction of the delay randomly added or removed between 0 and 1
+	RetryableErrors []string      // Regular expressions matched against the error message in addition to the built in classification
+
+	retryableErrors []*regexp.Regexp // compiled by compile when the dir starts
+}
+
+// Compiles the RetryableErrors
+func (r *RetryPolicy) compile() error {
+	compiled := make([]*regexp.Regexp, len(r.RetryableErrors))
+	for n, expression := range r.RetryableErrors {
+		var err error
+		compiled[n], err = regexp.Compile(expression)
+		if err != nil {
+			return fmt.Errorf("invalid retryable error %s: %w", expression, err)
+		}
+	}
+	r.retryableErrors = compiled
+	return nil
+}
+
+// Errors can implement this to mark themselves as retryable
+type temporary interface {
+	Temporary() bool
+}
+
+// Checks if the error is likely transient
+//
+// Network timeouts, refused or reset connections, FTP 4xx replies, errors with Temporary() returning true and errors
+// matching RetryableErrors are retryable. Other network errors such as failed logins are not
+func (r *RetryPolicy) retryable(err error) bool {
+	var netErr net.Error
+	var protoErr *textproto.Error
+	var tempErr temporary
+
+	switch {
+	case errors.As(err, &tempErr) && tempErr.Temporary():
+		return true
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return true
+	case errors.As(err, &protoErr):
+		return protoErr.Code >= 400 && protoErr.Code < 500
+	case errors.Is(err, os.ErrDeadlineExceeded),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.EPIPE):
+		return true
+	}
+
+	for _, expression := range r.retryableErrors {
+		if expression.MatchString(err.Error()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Delay before the next attempt after the given number of attempts
+func (r *RetryPolicy) delay(attempt uint) time.Duration {
+	delay := r.Backoff
+	for n := uint(1); n < attempt && delay < math.MaxInt64/2; n++ {
+		delay *= 2
+	}
+	if r.MaxBackoff > 0 {
+		delay = min(delay, r.MaxBackoff)
+	}
+	if r.Jitter > 0 {
+		delay += time.Duration(float64(delay) * r.Jitter * (rand.Float64()*2 - 1))
+	}
+	return max(delay, 0)
+}
+
+// Task parked by scheduleRetry until its timer fires
+type parkedRetry struct {
+	dir   *Dir
+	timer *time.Timer // nil if parked while stopping
+}
+
+// Parks the task and adds it back to the workers once the backoff has elapsed
+//
+// While the FileMonitor is stopping the task stays parked without a timer and is released by Stop
+func (d *Dir) scheduleRetry(task *fileTask) {
+	f := d.parent
+	delay := d.Retry.delay(task.attempt)
+	d.Stats.Retrying.Add(1)
+	d.log.Info().Str("filePath", task.originalPath()).Uint("attempt", task.attempt).Dur("delay", delay).Msg("parked file for retry")
+
+	f.retryLock.Lock()
+	defer f.retryLock.Unlock()
+	if f.retries == nil {
+		f.retries = make(map[*fileTask]parkedRetry)
+	}
+	parked := parkedRetry{dir: d}
+	if !f.retriesStopped {
+		parked.timer = time.AfterFunc(delay, func() { d.resumeRetry(task) })
+	}
+	f.retries[task] = parked
+}
+
+// Adds the parked task back to the workers unless Stop took it first
+func (d *Dir) resumeRetry(task *fileTask) {
+	f := d.parent
+	f.retryLock.Lock()
+	if _, ok := f.retries[task]; !ok || f.retriesStopped {
+		f.retryLock.Unlock()
+		return
+	}
+	delete(f.retries, task)
+	f.tasks.add() // while holding the lock so tasks are never added once Stop is draining
+	f.retryLock.Unlock()
+
+	d.Stats.Retrying.Add(^uint64(0))
+	// continues after the dir stopped so files are not stranded in the claim folder when the dir is replaced
+	if !f.send(f.ctx, func(worker uint) { d.runTask(worker, task) }) {
+		d.release(task.originalPath()) // picked up again on the next start
+	}
+}
+
+// Stops the timers of all parked tasks so none are added while stopping. Later retries stay parked without a timer
+func (f *FileMonitor) stopRetries() {
+	f.retryLock.Lock()
+	defer f.retryLock.Unlock()
+	f.retriesStopped = true
+	for _, parked := range f.retries {
+		if parked.timer != nil {
+			parked.timer.Stop()
+		}
+	}
+}
+
+// Releases all parked tasks so they are picked up again once started
+func (f *FileMonitor) releaseRetries() {
+	f.retryLock.Lock()
+	defer f.retryLock.Unlock()
+	for task, parked := range f.retries {
+		parked.dir.Stats.Retrying.Add(^uint64(0))
+		parked.dir.release(task.originalPath())
+	}
+	f.retries = nil
+}
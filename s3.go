@@ -0,0 +1,374 @@
+package fileMonitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+// Files larger than this are uploaded in parts by default
+const defaultS3PartSize = 16 << 20
+
+// Limit of a single upload by default so a stalled endpoint does not hold up a worker
+const defaultS3Timeout = time.Minute * 10
+
+// Connection settings shared by the S3 copier and source
+type s3Config struct {
+	endpoint        string
+	region          string
+	insecure        bool
+	accessKeyId     string
+	secretAccessKey string
+}
+
+// Creates a client using the access key if provided, otherwise credentials from the environment or instance role
+func (c *s3Config) newClient() (*minio.Client, error) {
+	var creds *credentials.Credentials
+	if c.accessKeyId != "" {
+		creds = credentials.NewStaticV4(c.accessKeyId, c.secretAccessKey, "")
+	} else {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{},
+		})
+	}
+
+	client, err := minio.New(c.endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: !c.insecure,
+		Region: c.region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create S3 client: %w", err)
+	}
+	return client, nil
+}
+
+// Converts the path of the file within the prefix into an object key
+func s3Key(outFileName string) string {
+	return strings.TrimLeft(filepath.ToSlash(outFileName), "/")
+}
+
+// Uploads files to a bucket of an S3 compatible object store such as AWS or MinIO
+//
+// Objects are keyed by Prefix followed by the path relative to the MonitorFolder. The MD5 of the content is sent
+// with each upload or part so the server rejects corrupted uploads
+type CopierS3 struct {
+	Endpoint              string // Host with an optional port such as "s3.us-east-1.amazonaws.com" or "minio:9000"
+	Region                string
+	Insecure              bool // Connects over HTTP instead of HTTPS
+	Bucket                string
+	Prefix                string
+	AccessKeyId           string        // Credentials are taken from the environment or the instance role if empty
+	SecretAccessKeySecret string        // Reference to the secret access key in a SecretProvider such as "env:S3_SECRET_KEY"
+	StorageClass          string        // Such as "STANDARD_IA" or "GLACIER". Defaults to the bucket default
+	ServerSideEncryption  string        // "AES256" or "aws:kms". Disabled if empty
+	KmsKeyId              string        // Key used with "aws:kms"
+	PartSize              uint64        // Files larger than this are uploaded in parts. Defaults to 16 MiB
+	Timeout               time.Duration // Limit of each upload including the conflict checks. Defaults to 10m
+	OnConflict            ConflictPolicy
+	PathTemplate          PathTemplate // Key within the Prefix
+
+	secrets *Secrets
+
+	clientLock sync.Mutex
+	client     *minio.Client // reused by all workers
+}
+
+func (c *CopierS3) UseSecrets(secrets *Secrets) {
+	c.secrets = secrets
+}
+
+func (c *CopierS3) getClient() (*minio.Client, error) {
+	c.clientLock.Lock()
+	defer c.clientLock.Unlock()
+	if c.client != nil {
+		return c.client, nil
+	}
+
+	config := &s3Config{
+		endpoint:    expandEnv(c.Endpoint),
+		region:      expandEnv(c.Region),
+		insecure:    c.Insecure,
+		accessKeyId: expandEnv(c.AccessKeyId),
+	}
+	if c.SecretAccessKeySecret != "" {
+		var err error
+		config.secretAccessKey, err = c.secrets.Resolve(c.SecretAccessKeySecret)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	client, err := config.newClient()
+	if err != nil {
+		return nil, err
+	}
+	c.client = client
+	return client, nil
+}
+
+func (c *CopierS3) putOptions() (minio.PutObjectOptions, error) {
+	options := minio.PutObjectOptions{
+		StorageClass:   c.StorageClass,
+		PartSize:       c.PartSize,
+		SendContentMd5: true,
+	}
+	if options.PartSize == 0 {
+		options.PartSize = defaultS3PartSize
+	}
+
+	switch c.ServerSideEncryption {
+	case "":
+	case "AES256":
+		options.ServerSideEncryption = encrypt.NewSSE()
+	case "aws:kms":
+		sse, err := encrypt.NewSSEKMS(c.KmsKeyId, nil)
+		if err != nil {
+			return options, fmt.Errorf("invalid KMS encryption: %w", err)
+		}
+		options.ServerSideEncryption = sse
+	default:
+		return options, fmt.Errorf("invalid server side encryption: %s", c.ServerSideEncryption)
+	}
+	return options, nil
+}
+
+func (c *CopierS3) Copy(inFilePath, monitorDir string) error {
+	return c.CopyTemplated(inFilePath, monitorDir, nil)
+}
+
+func (c *CopierS3) CopyTemplated(inFilePath, monitorDir string, values *TemplateValues) error {
+	key, err := c.PathTemplate.outFileName(inFilePath, monitorDir, expandEnv(c.Prefix), values)
+	if err != nil {
+		return err
+	}
+	client, err := c.getClient()
+	if err != nil {
+		return err
+	}
+	options, err := c.putOptions()
+	if err != nil {
+		return err
+	}
+
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = defaultS3Timeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	bucket := expandEnv(c.Bucket)
+	key, skip, err := c.OnConflict.resolve(&s3ConflictTarget{ctx, client, bucket}, s3Key(key), inFilePath)
+	if err != nil || skip {
+		return err
+	}
+	_, err = client.FPutObject(ctx, bucket, key, inFilePath, options)
+	if err != nil {
+		return fmt.Errorf("failed to upload file to S3: %w", err)
+	}
+	return nil
+}
+
+// Checks the settings without connecting to the endpoint
+func (c *CopierS3) ValidateConfig() error {
+	err := c.OnConflict.validate()
+	if err != nil {
+		return err
+	}
+	err = c.PathTemplate.validate()
+	if err != nil {
+		return err
+	}
+	_, err = c.putOptions()
+	return err
+}
+
+// Checks the bucket exists and the credentials can access it
+func (c *CopierS3) Validate() error {
+	err := c.ValidateConfig()
+	if err != nil {
+		return err
+	}
+	client, err := c.getClient()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), validateTimeout)
+	defer cancel()
+	exists, err := client.BucketExists(ctx, expandEnv(c.Bucket))
+	if err != nil {
+		return fmt.Errorf("unable to check bucket: %w", err)
+	} else if !exists {
+		return fmt.Errorf("bucket %s does not exist", expandEnv(c.Bucket))
+	}
+	return nil
+}
+
+type s3ConflictTarget struct {
+	ctx    context.Context // limits the checks to the timeout of the upload
+	client *minio.Client
+	bucket string
+}
+
+func (t *s3ConflictTarget) exists(key string) (bool, error) {
+	_, err := t.client.StatObject(t.ctx, t.bucket, key, minio.StatObjectOptions{})
+	if err == nil {
+		return true, nil
+	}
+	response := minio.ToErrorResponse(err)
+	if response.Code == "NoSuchKey" || response.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	return false, err
+}
+
+func (t *s3ConflictTarget) open(key string) (io.ReadCloser, error) {
+	return t.client.GetObject(t.ctx, t.bucket, key, minio.GetObjectOptions{})
+}
+
+func (c *CopierS3) GetType() CopierType {
+	return CopierTypeS3
+}
+
+func (c *CopierS3) MarshalJSON() ([]byte, error) {
+	type Alias CopierS3
+	return json.Marshal(&struct {
+		Type CopierType `json:"Type"`
+		*Alias
+		Timeout Duration
+	}{
+		Type:    c.GetType(),
+		Alias:   (*Alias)(c),
+		Timeout: Duration(c.Timeout),
+	})
+}
+
+func (c *CopierS3) UnmarshalJSON(data []byte) error {
+	type Alias CopierS3
+	aux := &struct {
+		*Alias
+		Timeout Duration
+	}{
+		Alias:   (*Alias)(c),
+		Timeout: Duration(c.Timeout),
+	}
+	err := json.Unmarshal(data, aux)
+	if err != nil {
+		return err
+	}
+	c.Timeout = time.Duration(aux.Timeout)
+	return nil
+}
+
+type sourceS3 struct {
+	client *minio.Client
+	bucket string
+	prefix string // ends with "/" unless the whole bucket is listed
+}
+
+// Opens a prefix within a bucket such as
+// "s3://s3.us-east-1.amazonaws.com/bucket/prefix?region=us-east-1&accessKeyId=KEY&secretAccessKeySecret=env:S3_SECRET"
+//
+// Set the insecure parameter to true to connect over HTTP. Without accessKeyId credentials are taken from the
+// environment or the instance role
+func openSourceS3(location *url.URL, secrets *Secrets) (Source, error) {
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(location.Path, "/"), "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("no bucket in url: %s", location.Redacted())
+	}
+
+	query := location.Query()
+	config := &s3Config{
+		endpoint:    location.Host,
+		region:      query.Get("region"),
+		insecure:    query.Get("insecure") == "true",
+		accessKeyId: query.Get("accessKeyId"),
+	}
+	if reference := query.Get("secretAccessKeySecret"); reference != "" {
+		var err error
+		config.secretAccessKey, err = secrets.Resolve(reference)
+		if err != nil {
+			return nil, err
+		}
+	}
+	client, err := config.newClient()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), validateTimeout)
+	defer cancel()
+	exists, err := client.BucketExists(ctx, bucket)
+	if err != nil {
+		return nil, fmt.Errorf("unable to check bucket: %w", err)
+	} else if !exists {
+		return nil, fmt.Errorf("bucket %s does not exist", bucket)
+	}
+
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &sourceS3{client: client, bucket: bucket, prefix: prefix}, nil
+}
+
+func (s *sourceS3) List() ([]SourceFile, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel() // stops the listing when returning early
+
+	var files []SourceFile
+	objects := s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: s.prefix, Recursive: true})
+	for object := range objects {
+		if object.Err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", object.Err)
+		} else if strings.HasSuffix(object.Key, "/") {
+			continue // folder marker
+		}
+		files = append(files, SourceFile{
+			Path:    strings.TrimPrefix(object.Key, s.prefix),
+			Size:    object.Size,
+			ModTime: object.LastModified,
+		})
+	}
+	return files, nil
+}
+
+func (s *sourceS3) Open(filePath string) (io.ReadCloser, error) {
+	return s.client.GetObject(context.Background(), s.bucket, s.prefix+filePath, minio.GetObjectOptions{})
+}
+
+func (s *sourceS3) Remove(filePath string) error {
+	return s.client.RemoveObject(context.Background(), s.bucket, s.prefix+filePath, minio.RemoveObjectOptions{})
+}
+
+// Copies the object on the server and removes the original as objects can not be renamed
+func (s *sourceS3) Rename(oldPath, newPath string) error {
+	_, err := s.client.CopyObject(context.Background(),
+		minio.CopyDestOptions{Bucket: s.bucket, Object: path.Join(s.prefix, newPath)},
+		minio.CopySrcOptions{Bucket: s.bucket, Object: s.prefix + oldPath},
+	)
+	if err != nil {
+		return fmt.Errorf("unable to copy object: %w", err)
+	}
+	return s.Remove(oldPath)
+}
+
+func (s *sourceS3) Close() error {
+	return nil
+}
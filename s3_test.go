@@ -0,0 +1,373 @@
+package fileMonitor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/treavorj/zerolog"
+	"github.com/treavorj/zerolog/log"
+	"github.com/treavorj/zerolog/pkgerrors"
+)
+
+// In-process stand-in for an S3 compatible server handling the path style requests used by the copier
+//
+// Requests are not authenticated. Content-MD5 headers are verified like a real server
+type fakeS3 struct {
+	lock    sync.Mutex
+	buckets map[string]map[string][]byte
+	uploads map[string]map[int][]byte // parts keyed by upload id and part number
+	modTime time.Time
+}
+
+func newFakeS3(buckets ...string) *fakeS3 {
+	s := &fakeS3{
+		buckets: make(map[string]map[string][]byte),
+		uploads: make(map[string]map[int][]byte),
+		modTime: time.Now().Add(-time.Minute),
+	}
+	for _, bucket := range buckets {
+		s.buckets[bucket] = make(map[string][]byte)
+	}
+	return s
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	bucketName, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	bucket, ok := s.buckets[bucketName]
+	if !ok {
+		s.error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	query := r.URL.Query()
+
+	switch {
+	case key == "" && r.Method == http.MethodHead:
+	case key == "" && r.Method == http.MethodGet && query.Get("list-type") == "2":
+		s.list(w, bucketName, bucket, query.Get("prefix"))
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		uploadId := strconv.Itoa(len(s.uploads) + 1)
+		s.uploads[uploadId] = make(map[int][]byte)
+		s.xml(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadId string
+		}{Bucket: bucketName, Key: key, UploadId: uploadId})
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		body, ok := s.body(w, r)
+		if !ok {
+			return
+		}
+		partNumber, _ := strconv.Atoi(query.Get("partNumber"))
+		s.uploads[query.Get("uploadId")][partNumber] = body
+		w.Header().Set("ETag", etag(body))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		var complete struct {
+			Parts []struct{ PartNumber int } `xml:"Part"`
+		}
+		err := xml.NewDecoder(r.Body).Decode(&complete)
+		if err != nil {
+			s.error(w, http.StatusBadRequest, "MalformedXML")
+			return
+		}
+		var object []byte
+		for _, part := range complete.Parts {
+			object = append(object, s.uploads[query.Get("uploadId")][part.PartNumber]...)
+		}
+		delete(s.uploads, query.Get("uploadId"))
+		bucket[key] = object
+		s.xml(w, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Bucket  string
+			Key     string
+			ETag    string
+		}{Bucket: bucketName, Key: key, ETag: etag(object)})
+	case r.Method == http.MethodPut:
+		body, ok := s.body(w, r)
+		if !ok {
+			return
+		}
+		bucket[key] = body
+		w.Header().Set("ETag", etag(body))
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		object, ok := bucket[key]
+		if !ok {
+			s.error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", etag(object))
+		w.Header().Set("Last-Modified", s.modTime.UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.Itoa(len(object)))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(object)
+		}
+	case r.Method == http.MethodDelete:
+		delete(bucket, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// Lists all objects with the prefix in a single page of a ListObjectsV2 response
+func (s *fakeS3) list(w http.ResponseWriter, bucketName string, bucket map[string][]byte, prefix string) {
+	type object struct {
+		Key          string
+		LastModified time.Time
+		ETag         string
+		Size         int
+	}
+	var contents []object
+	for key, data := range bucket {
+		if strings.HasPrefix(key, prefix) {
+			contents = append(contents, object{Key: key, LastModified: s.modTime.UTC(), ETag: etag(data), Size: len(data)})
+		}
+	}
+	sort.Slice(contents, func(i, j int) bool { return contents[i].Key < contents[j].Key })
+
+	s.xml(w, struct {
+		XMLName  xml.Name `xml:"ListBucketResult"`
+		Name     string
+		Prefix   string
+		KeyCount int
+		Contents []object
+	}{Name: bucketName, Prefix: prefix, KeyCount: len(contents), Contents: contents})
+}
+
+// Reads the body decoding streaming signatures and verifies its Content-MD5
+func (s *fakeS3) body(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	var body []byte
+	var err error
+	if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		body, err = decodeAwsChunked(r.Body)
+	} else {
+		body, err = io.ReadAll(r.Body)
+	}
+	if err != nil {
+		s.error(w, http.StatusBadRequest, "IncompleteBody")
+		return nil, false
+	}
+
+	if contentMd5 := r.Header.Get("Content-Md5"); contentMd5 != "" {
+		sum := md5.Sum(body)
+		if contentMd5 != base64.StdEncoding.EncodeToString(sum[:]) {
+			s.error(w, http.StatusBadRequest, "BadDigest")
+			return nil, false
+		}
+	}
+	return body, true
+}
+
+// Decodes a body sent with the aws-chunked encoding of streaming signatures
+func decodeAwsChunked(reader io.Reader) ([]byte, error) {
+	buffered := bufio.NewReader(reader)
+	var body []byte
+	for {
+		header, err := buffered.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(header), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, err
+		} else if size == 0 {
+			return body, nil
+		}
+
+		chunk := make([]byte, size+2) // includes the trailing CRLF
+		_, err = io.ReadFull(buffered, chunk)
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, chunk[:size]...)
+	}
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func (s *fakeS3) xml(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(value)
+}
+
+func (s *fakeS3) error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func TestCopierS3(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(newFakeS3("archive"))
+	defer server.Close()
+
+	secretFolder := t.TempDir()
+	err := os.WriteFile(filepath.Join(secretFolder, "s3_secret"), []byte("secret\n"), 0600)
+	if err != nil {
+		t.Fatalf("failed to write secret: %v", err)
+	}
+	secrets := newSecrets()
+	secrets.register("file", SecretProviderFile{Folder: secretFolder})
+
+	monitorFolder := t.TempDir()
+	small := []byte("a,b\n1,2\n")
+	large := bytes.Repeat([]byte("0123456789abcdef"), 6<<20/16) // spans two parts
+	for name, data := range map[string][]byte{"small.csv": small, "large.csv": large} {
+		err = os.MkdirAll(filepath.Join(monitorFolder, "sub"), os.ModePerm)
+		if err != nil {
+			t.Fatalf("failed to create sub folder: %v", err)
+		}
+		err = os.WriteFile(filepath.Join(monitorFolder, "sub", name), data, os.ModePerm)
+		if err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	copier := &CopierS3{
+		Endpoint:              strings.TrimPrefix(server.URL, "http://"),
+		Region:                "us-east-1",
+		Insecure:              true,
+		Bucket:                "archive",
+		Prefix:                "instruments",
+		AccessKeyId:           "key",
+		SecretAccessKeySecret: "file:s3_secret",
+		PartSize:              5 << 20,
+	}
+	copier.UseSecrets(secrets)
+	err = copier.Validate()
+	if err != nil {
+		t.Fatalf("failed to validate copier: %v", err)
+	}
+
+	client, err := copier.getClient()
+	if err != nil {
+		t.Fatalf("failed to get client: %v", err)
+	}
+	for name, data := range map[string][]byte{"small.csv": small, "large.csv": large} {
+		err = copier.Copy(filepath.Join(monitorFolder, "sub", name), monitorFolder)
+		if err != nil {
+			t.Fatalf("failed to copy %s: %v", name, err)
+		}
+
+		object, err := client.GetObject(context.Background(), "archive", "instruments/sub/"+name, minio.GetObjectOptions{})
+		if err != nil {
+			t.Fatalf("failed to get object: %v", err)
+		}
+		uploaded, err := io.ReadAll(object)
+		object.Close()
+		if err != nil {
+			t.Errorf("failed to read %s: %v", name, err)
+		} else if !bytes.Equal(uploaded, data) {
+			t.Errorf("uploaded %s does not match: got %d bytes, expected %d", name, len(uploaded), len(data))
+		}
+	}
+
+	invalid := &CopierS3{Endpoint: copier.Endpoint, Insecure: true, Bucket: "missing", AccessKeyId: "key"}
+	if err := invalid.Validate(); err == nil {
+		t.Errorf("expected a missing bucket to fail validation")
+	}
+	invalid = &CopierS3{Endpoint: copier.Endpoint, Insecure: true, Bucket: "archive", ServerSideEncryption: "rot13"}
+	if err := invalid.Validate(); err == nil {
+		t.Errorf("expected an invalid encryption to fail validation")
+	}
+
+	// endpoint which accepts requests but does not answer until the test ends
+	release := make(chan struct{})
+	stalled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer stalled.Close()
+	defer close(release)
+	timeout := &CopierS3{Endpoint: strings.TrimPrefix(stalled.URL, "http://"), Region: "us-east-1", Insecure: true, Bucket: "archive", AccessKeyId: "key", Timeout: time.Millisecond * 200}
+	start := time.Now()
+	if err := timeout.Copy(filepath.Join(monitorFolder, "sub", "small.csv"), monitorFolder); err == nil {
+		t.Errorf("expected a stalled endpoint to fail the copy")
+	} else if elapsed := time.Since(start); elapsed > time.Second*5 {
+		t.Errorf("expected the copy to give up after the timeout but took %v", elapsed)
+	}
+}
+
+func TestSourceS3(t *testing.T) {
+	t.Parallel()
+
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+	logger := log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339Nano}).With().Caller().Logger()
+
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(configFile, []byte("{}"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	fileMonitor, err := NewFileMonitor(context.Background(), logger, configFile)
+	if err != nil {
+		t.Fatalf("failed to create fileMonitor: %v", err)
+	}
+
+	fake := newFakeS3("vendor")
+	fake.buckets["vendor"]["drop/sub/remote.csv"] = []byte("a,b\n1,2\n")
+	fake.buckets["vendor"]["drop/ignored.txt"] = []byte("ignored")
+	fake.buckets["vendor"]["other/remote.csv"] = []byte("outside of the prefix")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	destination := t.TempDir()
+	dir := &Dir{
+		Name:             t.Name(),
+		MonitorFolder:    "s3://" + strings.TrimPrefix(server.URL, "http://") + "/vendor/drop?region=us-east-1&insecure=true&accessKeyId=key",
+		StagingFolder:    t.TempDir(),
+		MonitorFrequency: time.Millisecond * 50,
+		MatchGroups:      []MatchGroup{{Expression: `\.csv$`}},
+		Stability:        Stability{Observations: 2},
+		Copiers:          []Copier{&CopierLocal{Destination: destination}},
+	}
+	err = fileMonitor.AddDir(dir)
+	if err != nil {
+		t.Fatalf("failed to add dir: %v", err)
+	}
+
+	time.Sleep(time.Millisecond * 500)
+	data, err := os.ReadFile(filepath.Join(destination, "sub", "remote.csv"))
+	if err != nil || string(data) != "a,b\n1,2\n" {
+		t.Fatalf("remote object should have been copied: %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(destination, "remote.csv")); !os.IsNotExist(err) {
+		t.Errorf("object outside of the prefix should not have been pulled: %v", err)
+	}
+
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	if _, ok := fake.buckets["vendor"]["drop/sub/remote.csv"]; ok {
+		t.Errorf("processed object should have been deleted")
+	}
+	if _, ok := fake.buckets["vendor"]["drop/ignored.txt"]; !ok {
+		t.Errorf("object not matching should have been left in the bucket")
+	}
+}
@@ -0,0 +1,271 @@
+package fileMonitor
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Folder used by the file secret provider for relative names. Matches where Docker and Kubernetes mount secrets
+const defaultSecretFolder = "/run/secrets"
+
+// Resolves secrets by name so credentials are never stored in the config
+type SecretProvider interface {
+	Secret(name string) (string, error)
+}
+
+// Copiers needing secrets implement this to receive the providers registered on the FileMonitor
+type SecretConsumer interface {
+	UseSecrets(secrets *Secrets)
+}
+
+// Secret providers registered by name
+//
+// Secrets are referenced in the config as "provider:name" such as "env:FTP_PASSWORD" or "file:ftp_password"
+type Secrets struct {
+	lock      sync.RWMutex
+	providers map[string]SecretProvider
+}
+
+// Creates the registry with the built in "env" and "file" providers
+func newSecrets() *Secrets {
+	return &Secrets{providers: map[string]SecretProvider{
+		"env":  SecretProviderEnv{},
+		"file": SecretProviderFile{},
+	}}
+}
+
+func (s *Secrets) register(name string, provider SecretProvider) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.providers[name] = provider
+}
+
+// Resolves a reference of the form "provider:name"
+func (s *Secrets) Resolve(reference string) (string, error) {
+	if s == nil {
+		return "", fmt.Errorf("no secret providers available to resolve %s", reference)
+	}
+	providerName, name, ok := strings.Cut(reference, ":")
+	if !ok || name == "" {
+		return "", fmt.Errorf("invalid secret reference %s. Must be of the form provider:name", reference)
+	}
+
+	s.lock.RLock()
+	provider, ok := s.providers[providerName]
+	s.lock.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("no secret provider registered with name: %s", providerName)
+	}
+
+	secret, err := provider.Secret(name)
+	if err != nil {
+		return "", fmt.Errorf("unable to get secret %s: %w", reference, err)
+	}
+	return secret, nil
+}
+
+// Registers the provider so secrets can be referenced as "name:secret". Replaces any provider with the same name
+func (f *FileMonitor) RegisterSecretProvider(name string, provider SecretProvider) {
+	if f.secrets == nil {
+		f.secrets = newSecrets()
+	}
+	f.secrets.register(name, provider)
+}
+
+// Passes the secret providers to the source and all copiers which use them
+func (d *Dir) useSecrets(secrets *Secrets) {
+	d.secrets = secrets
+	for _, copiers := range [][]Copier{d.Copiers, d.ErrorCopiers} {
+		for _, copier := range copiers {
+			if consumer, ok := copier.(SecretConsumer); ok {
+				consumer.UseSecrets(secrets)
+			}
+		}
+	}
+}
+
+// Warns about copiers holding a plaintext password from an older config as it is lost on the next save
+func (d *Dir) warnPlaintextPasswords() {
+	for _, copiers := range [][]Copier{d.Copiers, d.ErrorCopiers} {
+		for n, copier := range copiers {
+			if ftpCopier, ok := copier.(*CopierFtp); ok && ftpCopier.legacyPassword && ftpCopier.PasswordSecret == "" {
+				d.log.Warn().Int("copier", n).Str("server", ftpCopier.Server).
+					Msg("plaintext password from an older config is only kept in memory and will not be saved. Store it in a secret provider and reference it with PasswordSecret")
+			}
+		}
+	}
+}
+
+// Reads secrets from environment variables
+type SecretProviderEnv struct{}
+
+func (SecretProviderEnv) Secret(name string) (string, error) {
+	secret, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return secret, nil
+}
+
+// Reads secrets from files such as Docker and Kubernetes secrets. Trailing newlines are removed
+type SecretProviderFile struct {
+	Folder string // Relative names are within this folder. Defaults to /run/secrets
+}
+
+func (p SecretProviderFile) Secret(name string) (string, error) {
+	filePath := name
+	if !filepath.IsAbs(filePath) {
+		folder := p.Folder
+		if folder == "" {
+			folder = defaultSecretFolder
+		}
+		filePath = filepath.Join(folder, name)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// Local file of secrets encrypted with AES-GCM using a key derived from a passphrase with scrypt
+type Keystore struct {
+	path string
+	salt []byte
+	key  []byte
+
+	lock    sync.RWMutex
+	secrets map[string]string
+}
+
+// Format of the keystore on disk
+type keystoreFile struct {
+	Salt  []byte
+	Nonce []byte
+	Data  []byte
+}
+
+func deriveKeystoreKey(passphrase string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+}
+
+// Opens the keystore at path or creates an empty one if it does not exist yet
+func OpenKeystore(path, passphrase string) (*Keystore, error) {
+	keystore := &Keystore{path: path, secrets: make(map[string]string)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		keystore.salt = make([]byte, 16)
+		_, err = rand.Read(keystore.salt)
+		if err != nil {
+			return nil, fmt.Errorf("unable to generate salt: %w", err)
+		}
+		keystore.key, err = deriveKeystoreKey(passphrase, keystore.salt)
+		if err != nil {
+			return nil, fmt.Errorf("unable to derive key: %w", err)
+		}
+		return keystore, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read keystore: %w", err)
+	}
+
+	var file keystoreFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unmarshal keystore: %w", err)
+	}
+	keystore.salt = file.Salt
+	keystore.key, err = deriveKeystoreKey(passphrase, file.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("unable to derive key: %w", err)
+	}
+
+	aead, err := keystore.aead()
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, file.Nonce, file.Data, nil)
+	if err != nil {
+		return nil, errors.New("unable to decrypt keystore. The passphrase is likely incorrect")
+	}
+	err = json.Unmarshal(plaintext, &keystore.secrets)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unmarshal secrets: %w", err)
+	}
+	return keystore, nil
+}
+
+func (k *Keystore) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k.key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func (k *Keystore) Secret(name string) (string, error) {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+
+	secret, ok := k.secrets[name]
+	if !ok {
+		return "", fmt.Errorf("secret %s not found in keystore", name)
+	}
+	return secret, nil
+}
+
+// Stores the secret and saves the keystore
+func (k *Keystore) Set(name, secret string) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	k.secrets[name] = secret
+	return k.save()
+}
+
+// Removes the secret and saves the keystore
+func (k *Keystore) Delete(name string) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	delete(k.secrets, name)
+	return k.save()
+}
+
+func (k *Keystore) save() error {
+	plaintext, err := json.Marshal(k.secrets)
+	if err != nil {
+		return fmt.Errorf("unable to marshal secrets: %w", err)
+	}
+
+	aead, err := k.aead()
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return fmt.Errorf("unable to generate nonce: %w", err)
+	}
+
+	data, err := json.Marshal(keystoreFile{
+		Salt:  k.salt,
+		Nonce: nonce,
+		Data:  aead.Seal(nil, nonce, plaintext, nil),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to marshal keystore: %w", err)
+	}
+	return writeFileAtomic(k.path, data, 0600)
+}
@@ -0,0 +1,129 @@
+package fileMonitor
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSecrets(t *testing.T) {
+	t.Setenv("TEST_SECRETS_PASSWORD", "from env")
+	secretFolder := t.TempDir()
+	err := os.WriteFile(filepath.Join(secretFolder, "password"), []byte("from file\n"), 0600)
+	if err != nil {
+		t.Fatalf("failed to write secret: %v", err)
+	}
+
+	keystorePath := filepath.Join(t.TempDir(), "keystore.json")
+	keystore, err := OpenKeystore(keystorePath, "passphrase")
+	if err != nil {
+		t.Fatalf("failed to create keystore: %v", err)
+	}
+	err = keystore.Set("password", "from keystore")
+	if err != nil {
+		t.Fatalf("failed to set secret: %v", err)
+	}
+	data, err := os.ReadFile(keystorePath)
+	if err != nil {
+		t.Fatalf("failed to read keystore: %v", err)
+	} else if strings.Contains(string(data), "from keystore") {
+		t.Errorf("expected keystore to be encrypted")
+	}
+
+	_, err = OpenKeystore(keystorePath, "wrong")
+	if err == nil {
+		t.Errorf("expected keystore to fail to open with the wrong passphrase")
+	}
+	keystore, err = OpenKeystore(keystorePath, "passphrase")
+	if err != nil {
+		t.Fatalf("failed to open keystore: %v", err)
+	}
+
+	fileMonitor := &FileMonitor{} // providers can be registered before NewFileMonitor sets the defaults
+	fileMonitor.RegisterSecretProvider("file", SecretProviderFile{Folder: secretFolder})
+	fileMonitor.RegisterSecretProvider("keystore", keystore)
+
+	for reference, expected := range map[string]string{
+		"env:TEST_SECRETS_PASSWORD": "from env",
+		"file:password":             "from file",
+		"keystore:password":         "from keystore",
+	} {
+		secret, err := fileMonitor.secrets.Resolve(reference)
+		if err != nil {
+			t.Errorf("failed to resolve %s: %v", reference, err)
+		} else if secret != expected {
+			t.Errorf("expected %s to resolve to %q but got %q", reference, expected, secret)
+		}
+	}
+	for _, reference := range []string{"password", "missing:password", "env:TEST_SECRETS_MISSING"} {
+		_, err := fileMonitor.secrets.Resolve(reference)
+		if err == nil {
+			t.Errorf("expected %s to fail to resolve", reference)
+		}
+	}
+
+	copier := &CopierFtp{Server: "localhost:21", Password: "plaintext", PasswordSecret: "keystore:password"}
+	data, err = json.Marshal(copier)
+	if err != nil {
+		t.Fatalf("failed to marshal copier: %v", err)
+	} else if strings.Contains(string(data), "plaintext") {
+		t.Errorf("expected password not to be marshaled: %s", data)
+	}
+	dir := &Dir{Copiers: []Copier{copier}}
+	dir.useSecrets(fileMonitor.secrets)
+	password, err := copier.password()
+	if err != nil || password != "from keystore" {
+		t.Errorf("expected copier to resolve its password from the keystore but got %q: %v", password, err)
+	}
+
+	// configs from before secret providers keep plaintext passwords in memory but never save them again
+	legacy := `{"Version": 1, "Dirs": {"legacy": {"Name": "legacy", "Copiers": [{"Type": 2, "Server": "localhost:21", "Password": "plaintext"}]}}}`
+	loaded := &FileMonitor{}
+	err = ConfigFormatJson.unmarshal([]byte(legacy), loaded)
+	if err != nil {
+		t.Fatalf("expected legacy config with a plaintext password to load but got: %v", err)
+	}
+	legacyCopier, ok := loaded.Dirs["legacy"].Copiers[0].(*CopierFtp)
+	if !ok || legacyCopier.Password != "plaintext" || !legacyCopier.legacyPassword {
+		t.Errorf("expected the plaintext password to be kept in memory but got %#v", loaded.Dirs["legacy"].Copiers[0])
+	}
+	data, err = json.Marshal(loaded)
+	if err != nil {
+		t.Fatalf("failed to marshal legacy config: %v", err)
+	} else if strings.Contains(string(data), "plaintext") {
+		t.Errorf("expected legacy password not to be saved: %s", data)
+	}
+}
+
+func TestValidateConfigFileSecrets(t *testing.T) {
+	t.Setenv("TEST_VALIDATE_PASSWORD", "password")
+	_, address := startFakeFtp(t, nil, false)
+
+	fileMonitor := &FileMonitor{Dirs: map[string]*Dir{
+		"secrets": {
+			Name:             "secrets",
+			MonitorFolder:    t.TempDir(),
+			MonitorFrequency: time.Second,
+			Copiers:          []Copier{&CopierFtp{Server: address, Username: "user", PasswordSecret: "env:TEST_VALIDATE_PASSWORD"}},
+		},
+	}}
+	data, err := json.Marshal(fileMonitor)
+	if err != nil {
+		t.Fatalf("failed to marshal config: %v", err)
+	}
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	err = os.WriteFile(configFile, data, os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	errs, err := ValidateConfigFile(configFile)
+	if err != nil {
+		t.Errorf("failed to validate config file: %v", err)
+	} else if len(errs) != 0 {
+		t.Errorf("expected the password secret to resolve with the default providers but got: %v", errs)
+	}
+}
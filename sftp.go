@@ -0,0 +1,396 @@
+package fileMonitor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Known hosts file used when none is configured
+const defaultKnownHostsFile = "~/.ssh/known_hosts"
+
+// SSH settings shared by the SFTP copier and source
+type sftpConfig struct {
+	server         string
+	username       string
+	password       string
+	privateKeyFile string
+	passphrase     string
+	knownHostsFile string
+}
+
+// Builds the client config. The host key of the server must be listed in the known hosts file
+func (c *sftpConfig) clientConfig() (*ssh.ClientConfig, error) {
+	var auth []ssh.AuthMethod
+	if c.privateKeyFile != "" {
+		key, err := os.ReadFile(expandHome(c.privateKeyFile))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read private key: %w", err)
+		}
+
+		var signer ssh.Signer
+		if c.passphrase == "" {
+			signer, err = ssh.ParsePrivateKey(key)
+		} else {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(c.passphrase))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse private key: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if c.password != "" {
+		auth = append(auth, ssh.Password(c.password))
+	}
+	if len(auth) == 0 {
+		return nil, fmt.Errorf("no password or private key provided")
+	}
+
+	knownHostsFile := c.knownHostsFile
+	if knownHostsFile == "" {
+		knownHostsFile = defaultKnownHostsFile
+	}
+	hostKeyCallback, err := knownhosts.New(expandHome(knownHostsFile))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load known hosts: %w", err)
+	}
+
+	return &ssh.ClientConfig{
+		User:            c.username,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         validateTimeout,
+	}, nil
+}
+
+// SFTP session over its own SSH connection
+type sftpClient struct {
+	*sftp.Client
+	conn *ssh.Client
+}
+
+// Connects to the server which defaults to port 22
+func dialSftp(config *sftpConfig) (*sftpClient, error) {
+	clientConfig, err := config.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	server := config.server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "22")
+	}
+	conn, err := ssh.Dial("tcp", server, clientConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SFTP server: %w", err)
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to start SFTP session: %w", err)
+	}
+	return &sftpClient{Client: client, conn: conn}, nil
+}
+
+func (c *sftpClient) Close() error {
+	return errors.Join(c.Client.Close(), c.conn.Close())
+}
+
+// Renames the file replacing any existing file at newPath
+func (c *sftpClient) replace(oldPath, newPath string) error {
+	err := c.PosixRename(oldPath, newPath)
+	if err == nil {
+		return nil
+	}
+
+	// server does not support the posix-rename extension
+	err = c.Remove(newPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("unable to replace existing file: %w", err)
+	}
+	return c.Rename(oldPath, newPath)
+}
+
+// Copies files to an SFTP server
+//
+// Files are written under a temporary name and renamed once complete so readers never see partial files
+type CopierSftp struct {
+	Server           string // Host with an optional port. Defaults to port 22
+	Username         string
+	Password         string `json:"-"` // Only set in code and never stored. Use PasswordSecret in the config
+	PasswordSecret   string // Reference to the password in a SecretProvider such as "env:SFTP_PASSWORD"
+	PrivateKeyFile   string // Private key used to authenticate instead of or in addition to the password
+	PassphraseSecret string // Reference to the passphrase of an encrypted PrivateKeyFile
+	KnownHostsFile   string // Host key of the server must be listed here. Defaults to ~/.ssh/known_hosts
+	Destination      string
+	OnConflict       ConflictPolicy
+	PathTemplate     PathTemplate
+
+	secrets *Secrets
+}
+
+func (c *CopierSftp) UseSecrets(secrets *Secrets) {
+	c.secrets = secrets
+}
+
+func (c *CopierSftp) config() (*sftpConfig, error) {
+	config := &sftpConfig{
+		server:         expandEnv(c.Server),
+		username:       expandEnv(c.Username),
+		password:       c.Password,
+		privateKeyFile: expandEnv(c.PrivateKeyFile),
+		knownHostsFile: expandEnv(c.KnownHostsFile),
+	}
+
+	var err error
+	if c.PasswordSecret != "" {
+		config.password, err = c.secrets.Resolve(c.PasswordSecret)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.PassphraseSecret != "" {
+		config.passphrase, err = c.secrets.Resolve(c.PassphraseSecret)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+func (c *CopierSftp) dial() (*sftpClient, error) {
+	config, err := c.config()
+	if err != nil {
+		return nil, err
+	}
+	return dialSftp(config)
+}
+
+func (c *CopierSftp) Copy(inFilePath, monitorDir string) error {
+	return c.CopyTemplated(inFilePath, monitorDir, nil)
+}
+
+func (c *CopierSftp) CopyTemplated(inFilePath, monitorDir string, values *TemplateValues) error {
+	outFileName, err := c.PathTemplate.outFileName(inFilePath, monitorDir, expandEnv(c.Destination), values)
+	if err != nil {
+		return err
+	}
+	outFileName = filepath.ToSlash(outFileName)
+
+	client, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	inFile, err := os.Open(inFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to open the file: %w", err)
+	}
+	defer inFile.Close()
+
+	err = client.MkdirAll(path.Dir(outFileName))
+	if err != nil {
+		return fmt.Errorf("unable to create directory: %w", err)
+	}
+	outFileName, skip, err := c.OnConflict.resolve(&sftpConflictTarget{client}, outFileName, inFilePath)
+	if err != nil || skip {
+		return err
+	}
+
+	tempName := outFileName + ".part"
+	outFile, err := client.Create(tempName)
+	if err != nil {
+		return fmt.Errorf("error creating file: %w", err)
+	}
+	_, err = io.Copy(outFile, inFile)
+	if err != nil {
+		outFile.Close()
+		_ = client.Remove(tempName)
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
+	err = outFile.Close()
+	if err != nil {
+		_ = client.Remove(tempName)
+		return fmt.Errorf("failed to close outFile: %w", err)
+	}
+
+	err = client.replace(tempName, outFileName)
+	if err != nil {
+		_ = client.Remove(tempName)
+		return fmt.Errorf("unable to rename file into place: %w", err)
+	}
+	return nil
+}
+
+// Checks the settings without connecting to the server
+func (c *CopierSftp) ValidateConfig() error {
+	err := c.OnConflict.validate()
+	if err != nil {
+		return err
+	}
+	return c.PathTemplate.validate()
+}
+
+// Connects to the server and checks the Destination, or the nearest parent it would be created in, is a writable folder
+func (c *CopierSftp) Validate() error {
+	err := c.ValidateConfig()
+	if err != nil {
+		return err
+	}
+	client, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	destination := filepath.ToSlash(expandEnv(c.Destination))
+	if destination == "" {
+		return nil
+	}
+	folder, info, err := existingFolder(destination, client.Stat, path.Dir)
+	if err != nil {
+		return fmt.Errorf("invalid destination: %w", err)
+	}
+	return checkWritableMode(folder, info)
+}
+
+type sftpConflictTarget struct {
+	client *sftpClient
+}
+
+func (t *sftpConflictTarget) exists(name string) (bool, error) {
+	_, err := t.client.Stat(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (t *sftpConflictTarget) open(name string) (io.ReadCloser, error) {
+	return t.client.Open(name)
+}
+
+func (c *CopierSftp) GetType() CopierType {
+	return CopierTypeSftp
+}
+
+func (c *CopierSftp) MarshalJSON() ([]byte, error) {
+	type Alias CopierSftp
+	return json.Marshal(&struct {
+		Type CopierType `json:"Type"`
+		*Alias
+	}{
+		Type:  c.GetType(),
+		Alias: (*Alias)(c),
+	})
+}
+
+type sourceSftp struct {
+	client *sftpClient
+	folder string
+}
+
+// Opens a folder on an SFTP server such as
+// "sftp://user@server:22/data/in?passwordSecret=env:SFTP_PASSWORD&privateKeyFile=~/.ssh/id_ed25519"
+//
+// The parameters passphraseSecret and knownHostsFile match those of the copier
+func openSourceSftp(location *url.URL, secrets *Secrets) (Source, error) {
+	query := location.Query()
+	config := &sftpConfig{
+		server:         location.Host,
+		username:       location.User.Username(),
+		privateKeyFile: query.Get("privateKeyFile"),
+		knownHostsFile: query.Get("knownHostsFile"),
+	}
+
+	var err error
+	if reference := query.Get("passwordSecret"); reference != "" {
+		config.password, err = secrets.Resolve(reference)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if reference := query.Get("passphraseSecret"); reference != "" {
+		config.passphrase, err = secrets.Resolve(reference)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	client, err := dialSftp(config)
+	if err != nil {
+		return nil, err
+	}
+
+	source := &sourceSftp{client: client, folder: location.Path}
+	if source.folder == "" {
+		source.folder = "." // home folder of the user
+	}
+	_, err = client.Stat(source.folder)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("unable to read folder: %w", err)
+	}
+	return source, nil
+}
+
+func (s *sourceSftp) List() ([]SourceFile, error) {
+	var files []SourceFile
+	err := s.list("", &files)
+	return files, err
+}
+
+func (s *sourceSftp) list(relDir string, files *[]SourceFile) error {
+	entries, err := s.client.ReadDir(path.Join(s.folder, relDir))
+	if err != nil {
+		return fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		relPath := path.Join(relDir, entry.Name())
+		if entry.IsDir() {
+			err = s.list(relPath, files)
+			if err != nil {
+				return err
+			}
+			continue
+		} else if !entry.Mode().IsRegular() {
+			continue
+		}
+		*files = append(*files, SourceFile{Path: relPath, Size: entry.Size(), ModTime: entry.ModTime()})
+	}
+	return nil
+}
+
+func (s *sourceSftp) Open(filePath string) (io.ReadCloser, error) {
+	return s.client.Open(path.Join(s.folder, filePath))
+}
+
+func (s *sourceSftp) Remove(filePath string) error {
+	return s.client.Remove(path.Join(s.folder, filePath))
+}
+
+func (s *sourceSftp) Rename(oldPath, newPath string) error {
+	newPath = path.Join(s.folder, newPath)
+	err := s.client.MkdirAll(path.Dir(newPath))
+	if err != nil {
+		return fmt.Errorf("unable to create directory: %w", err)
+	}
+	return s.client.replace(path.Join(s.folder, oldPath), newPath)
+}
+
+func (s *sourceSftp) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,276 @@
+package fileMonitor
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/treavorj/zerolog"
+	"github.com/treavorj/zerolog/log"
+	"github.com/treavorj/zerolog/pkgerrors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// In-process SSH server serving the local filesystem over SFTP
+//
+// Accepts the password "password" and the returned private key. Returns the address and a known hosts file
+func startSftpServer(t *testing.T) (addr, knownHostsFile string, privateKey []byte) {
+	t.Helper()
+
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate host key: %v", err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatalf("failed to create host signer: %v", err)
+	}
+
+	clientPublic, clientKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate client key: %v", err)
+	}
+	clientPublicKey, err := ssh.NewPublicKey(clientPublic)
+	if err != nil {
+		t.Fatalf("failed to create client public key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(clientKey, "")
+	if err != nil {
+		t.Fatalf("failed to marshal client key: %v", err)
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if string(password) != "password" {
+				return nil, os.ErrPermission
+			}
+			return nil, nil
+		},
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(clientPublicKey.Marshal()) {
+				return nil, os.ErrPermission
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSftp(conn, config)
+		}
+	}()
+
+	knownHostsFile = filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(listener.Addr().String())}, hostSigner.PublicKey())
+	err = os.WriteFile(knownHostsFile, []byte(line+"\n"), 0600)
+	if err != nil {
+		t.Fatalf("failed to write known hosts: %v", err)
+	}
+	return listener.Addr().String(), knownHostsFile, pem.EncodeToMemory(block)
+}
+
+func serveSftp(conn net.Conn, config *ssh.ServerConfig) {
+	serverConn, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	defer serverConn.Close()
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for request := range requests {
+				isSftp := request.Type == "subsystem" && strings.HasSuffix(string(request.Payload), "sftp")
+				_ = request.Reply(isSftp, nil)
+				if !isSftp {
+					continue
+				}
+
+				server, err := sftp.NewServer(channel)
+				if err == nil {
+					_ = server.Serve()
+				}
+				channel.Close()
+			}
+		}()
+	}
+}
+
+func TestCopierSftp(t *testing.T) {
+	t.Parallel()
+
+	addr, knownHostsFile, privateKey := startSftpServer(t)
+	keyFile := filepath.Join(t.TempDir(), "id_ed25519")
+	err := os.WriteFile(keyFile, privateKey, 0600)
+	if err != nil {
+		t.Fatalf("failed to write private key: %v", err)
+	}
+
+	monitorFolder := t.TempDir()
+	filePath := filepath.Join(monitorFolder, "sub", "sftp.csv")
+	err = os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to create sub folder: %v", err)
+	}
+	err = os.WriteFile(filePath, []byte("a,b\n1,2\n"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		copier *CopierSftp
+	}{
+		{"password", &CopierSftp{Username: "user", Password: "password"}},
+		{"privateKey", &CopierSftp{Username: "user", PrivateKeyFile: keyFile}},
+	}
+	for _, test := range tests {
+		destination := t.TempDir()
+		test.copier.Server = addr
+		test.copier.KnownHostsFile = knownHostsFile
+		test.copier.Destination = filepath.Join(destination, "nested")
+
+		err = test.copier.Copy(filePath, monitorFolder)
+		if err != nil {
+			t.Errorf("%s: failed to copy file: %v", test.name, err)
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(destination, "nested", "sub", "sftp.csv"))
+		if err != nil || string(data) != "a,b\n1,2\n" {
+			t.Errorf("%s: unexpected copied file: %q, %v", test.name, data, err)
+		}
+		if _, err := os.Stat(filepath.Join(destination, "nested", "sub", "sftp.csv.part")); !os.IsNotExist(err) {
+			t.Errorf("%s: temporary file should have been renamed: %v", test.name, err)
+		}
+	}
+
+	missing := filepath.Join(t.TempDir(), "missing", "destination")
+	copier := &CopierSftp{Server: addr, Username: "user", Password: "password", KnownHostsFile: knownHostsFile, Destination: missing}
+	if err := copier.Validate(); err != nil {
+		t.Errorf("expected a missing destination to be valid but got: %v", err)
+	} else if _, err := os.Stat(filepath.Dir(missing)); !os.IsNotExist(err) {
+		t.Errorf("validate should not create the destination: %v", err)
+	}
+
+	copier = &CopierSftp{Server: addr, Username: "user", Password: "wrong", KnownHostsFile: knownHostsFile}
+	if err := copier.Validate(); err == nil {
+		t.Errorf("expected a wrong password to fail")
+	}
+
+	otherKnownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	err = os.WriteFile(otherKnownHosts, nil, 0600)
+	if err != nil {
+		t.Fatalf("failed to write known hosts: %v", err)
+	}
+	copier = &CopierSftp{Server: addr, Username: "user", Password: "password", KnownHostsFile: otherKnownHosts}
+	if err := copier.Validate(); err == nil || !strings.Contains(err.Error(), "knownhosts") {
+		t.Errorf("expected an unknown host key to be rejected but got: %v", err)
+	}
+}
+
+func TestSourceSftp(t *testing.T) {
+	t.Parallel()
+
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+	logger := log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339Nano}).With().Caller().Logger()
+
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(configFile, []byte("{}"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	fileMonitor, err := NewFileMonitor(context.Background(), logger, configFile)
+	if err != nil {
+		t.Fatalf("failed to create fileMonitor: %v", err)
+	}
+
+	addr, knownHostsFile, privateKey := startSftpServer(t)
+	keyFile := filepath.Join(t.TempDir(), "id_ed25519")
+	err = os.WriteFile(keyFile, privateKey, 0600)
+	if err != nil {
+		t.Fatalf("failed to write private key: %v", err)
+	}
+
+	remoteFolder := t.TempDir()
+	files := map[string]string{"sub/remote.csv": "a,b\n1,2\n", "ignored.txt": "ignored"}
+	for name, content := range files {
+		filePath := filepath.Join(remoteFolder, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+		if err != nil {
+			t.Fatalf("failed to create folder: %v", err)
+		}
+		err = os.WriteFile(filePath, []byte(content), os.ModePerm)
+		if err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	query := url.Values{"privateKeyFile": {keyFile}, "knownHostsFile": {knownHostsFile}}
+	destination := t.TempDir()
+	dir := &Dir{
+		Name:             t.Name(),
+		MonitorFolder:    "sftp://user@" + addr + filepath.ToSlash(remoteFolder) + "?" + query.Encode(),
+		StagingFolder:    t.TempDir(),
+		MonitorFrequency: time.Millisecond * 50,
+		MatchGroups:      []MatchGroup{{Expression: `\.csv$`}},
+		Stability:        Stability{Observations: 2},
+		OnSuccess:        Disposition{Action: DispositionArchive, ArchiveFolder: "archive"},
+		Copiers:          []Copier{&CopierLocal{Destination: destination}},
+	}
+	if errs := dir.Validate(); len(errs) != 0 {
+		t.Fatalf("expected the sftp dir to be valid: %v", errs)
+	}
+	err = fileMonitor.AddDir(dir)
+	if err != nil {
+		t.Fatalf("failed to add dir: %v", err)
+	}
+
+	time.Sleep(time.Millisecond * 500)
+	data, err := os.ReadFile(filepath.Join(destination, "sub", "remote.csv"))
+	if err != nil || string(data) != "a,b\n1,2\n" {
+		t.Fatalf("remote file should have been copied: %q, %v", data, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(remoteFolder, "sub", "remote.csv")); !os.IsNotExist(err) {
+		t.Errorf("remote file should have been moved: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(remoteFolder, "archive", "sub", "remote.csv")); err != nil {
+		t.Errorf("remote file should have been archived on the server: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(remoteFolder, "archive", "archive")); !os.IsNotExist(err) {
+		t.Errorf("archived file should not have been pulled again: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(remoteFolder, "ignored.txt")); err != nil {
+		t.Errorf("file not matching should have been left on the server: %v", err)
+	}
+}
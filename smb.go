@@ -0,0 +1,446 @@
+package fileMonitor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hirochachacha/go-smb2"
+)
+
+// Timeout when connecting to and logging in to SMB servers
+const smbDialTimeout = time.Second * 10
+
+// Operations on a share used by the SMB copier and source so they can be tested without a server
+type smbFs interface {
+	Stat(name string) (os.FileInfo, error)
+	ReadDir(name string) ([]os.FileInfo, error)
+	Open(name string) (io.ReadCloser, error)
+	Create(name string) (io.WriteCloser, error)
+	MkdirAll(name string, perm os.FileMode) error
+	Remove(name string) error
+	Rename(oldPath, newPath string) error
+	Close() error
+}
+
+// Share of an SMB2/3 server accessed directly without mounting it
+type smbShare struct {
+	*smb2.Share
+	conn    net.Conn
+	session *smb2.Session
+}
+
+// Connects to the share with NTLM authentication. The server defaults to port 445
+func dialSmb(server, shareName, domain, username, password string) (*smbShare, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "445")
+	}
+
+	conn, err := net.DialTimeout("tcp", server, smbDialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SMB server: %w", err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(smbDialTimeout))
+
+	dialer := &smb2.Dialer{
+		Initiator: &smb2.NTLMInitiator{
+			User:     username,
+			Password: password,
+			Domain:   domain,
+		},
+	}
+	session, err := dialer.Dial(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to log in to SMB server: %w", err)
+	}
+
+	share, err := session.Mount(shareName)
+	if err != nil {
+		_ = session.Logoff()
+		conn.Close()
+		return nil, fmt.Errorf("failed to open share %s: %w", shareName, err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	return &smbShare{Share: share, conn: conn, session: session}, nil
+}
+
+func (s *smbShare) Open(name string) (io.ReadCloser, error) {
+	file, err := s.Share.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (s *smbShare) Create(name string) (io.WriteCloser, error) {
+	file, err := s.Share.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (s *smbShare) Close() error {
+	return errors.Join(s.Umount(), s.session.Logoff(), s.conn.Close())
+}
+
+// Converts a local path into a path relative to the root of the share
+func smbPath(filePath string) string {
+	return strings.TrimLeft(filepath.ToSlash(filePath), "/")
+}
+
+// Renames the file replacing any existing file at newPath as renames do not replace existing files on SMB
+//
+// The existing file is moved aside and only removed once the rename succeeded so it is restored if the rename fails
+func smbReplace(share smbFs, oldPath, newPath string) error {
+	err := share.Rename(oldPath, newPath)
+	if err == nil {
+		return nil
+	} else if _, statErr := share.Stat(newPath); statErr != nil {
+		return err
+	}
+
+	asidePath := newPath + ".replaced"
+	err = share.Remove(asidePath) // left by an earlier replace which failed to clean up
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to remove previously replaced file: %w", err)
+	}
+	err = share.Rename(newPath, asidePath)
+	if err != nil {
+		return fmt.Errorf("unable to move existing file aside: %w", err)
+	}
+
+	err = share.Rename(oldPath, newPath)
+	if err != nil {
+		restoreErr := share.Rename(asidePath, newPath)
+		if restoreErr != nil {
+			return fmt.Errorf("unable to restore existing file after the rename failed: %w", errors.Join(err, restoreErr))
+		}
+		return err
+	}
+	_ = share.Remove(asidePath) // the file is already in place so a leftover is removed by the next replace
+	return nil
+}
+
+// Copies files to an SMB2/3 share without mounting it
+//
+// Files are written under a temporary name and renamed once complete so readers never see partial files. The connection
+// is shared by all workers using the copier and replaced if it stops responding
+type CopierSmb struct {
+	Server         string // Host with an optional port. Defaults to port 445
+	Share          string
+	Domain         string
+	Username       string
+	Password       string `json:"-"` // Only set in code and never stored. Use PasswordSecret in the config
+	PasswordSecret string // Reference to the password in a SecretProvider such as "env:SMB_PASSWORD"
+	Destination    string // Folder within the share
+	OnConflict     ConflictPolicy
+	PathTemplate   PathTemplate
+
+	secrets *Secrets
+	dialer  func() (smbFs, error) // replaces dial in tests
+
+	shareLock sync.Mutex
+	share     smbFs // reused by all workers until it fails or the copier is closed
+}
+
+func (c *CopierSmb) UseSecrets(secrets *Secrets) {
+	c.secrets = secrets
+}
+
+// Resolves the password from PasswordSecret if provided
+func (c *CopierSmb) password() (string, error) {
+	if c.PasswordSecret == "" {
+		return c.Password, nil
+	}
+	return c.secrets.Resolve(c.PasswordSecret)
+}
+
+// Connects with a new connection outside of the shared one
+func (c *CopierSmb) dial() (smbFs, error) {
+	if c.dialer != nil {
+		return c.dialer()
+	}
+	password, err := c.password()
+	if err != nil {
+		return nil, err
+	}
+	return dialSmb(expandEnv(c.Server), expandEnv(c.Share), expandEnv(c.Domain), expandEnv(c.Username), password)
+}
+
+func (c *CopierSmb) getShare() (share smbFs, reused bool, err error) {
+	c.shareLock.Lock()
+	defer c.shareLock.Unlock()
+	if c.share != nil {
+		return c.share, true, nil
+	}
+	c.share, err = c.dial()
+	if err != nil {
+		c.share = nil // keeps the interface nil
+		return nil, false, err
+	}
+	return c.share, false, nil
+}
+
+// Closes the share unless another worker already replaced it
+func (c *CopierSmb) dropShare(share smbFs) {
+	c.shareLock.Lock()
+	if c.share == share {
+		c.share = nil
+	}
+	c.shareLock.Unlock()
+	_ = share.Close()
+}
+
+// Runs fn with the shared connection
+//
+// If fn fails and the share no longer responds the connection is closed. Failures on a reused connection are retried
+// on a new one as the server may have dropped it while idle
+func (c *CopierSmb) withShare(fn func(share smbFs) error) error {
+	for {
+		share, reused, err := c.getShare()
+		if err != nil {
+			return err
+		}
+
+		err = fn(share)
+		if err == nil {
+			return nil
+		} else if _, statErr := share.Stat("."); statErr == nil {
+			return err
+		}
+
+		c.dropShare(share)
+		if !reused {
+			return err
+		}
+	}
+}
+
+// Closes the shared connection. A later copy connects again
+func (c *CopierSmb) Close() error {
+	c.shareLock.Lock()
+	share := c.share
+	c.share = nil
+	c.shareLock.Unlock()
+
+	if share != nil {
+		return share.Close()
+	}
+	return nil
+}
+
+func (c *CopierSmb) Copy(inFilePath, monitorDir string) error {
+	return c.CopyTemplated(inFilePath, monitorDir, nil)
+}
+
+func (c *CopierSmb) CopyTemplated(inFilePath, monitorDir string, values *TemplateValues) error {
+	outFileName, err := c.PathTemplate.outFileName(inFilePath, monitorDir, expandEnv(c.Destination), values)
+	if err != nil {
+		return err
+	}
+	outFileName = smbPath(outFileName)
+	return c.withShare(func(share smbFs) error {
+		inFile, err := os.Open(inFilePath)
+		if err != nil {
+			return fmt.Errorf("failed to open the file: %w", err)
+		}
+		defer inFile.Close()
+
+		if dir := path.Dir(outFileName); dir != "." {
+			err = share.MkdirAll(dir, os.ModePerm)
+			if err != nil {
+				return fmt.Errorf("unable to create directory: %w", err)
+			}
+		}
+		outFileName, skip, err := c.OnConflict.resolve(&smbConflictTarget{share}, outFileName, inFilePath)
+		if err != nil || skip {
+			return err
+		}
+
+		tempName := outFileName + ".part"
+		outFile, err := share.Create(tempName)
+		if err != nil {
+			return fmt.Errorf("error creating file: %w", err)
+		}
+		_, err = io.Copy(outFile, inFile)
+		if err != nil {
+			outFile.Close()
+			_ = share.Remove(tempName)
+			return fmt.Errorf("failed to copy file: %w", err)
+		}
+		err = outFile.Close()
+		if err != nil {
+			_ = share.Remove(tempName)
+			return fmt.Errorf("failed to close outFile: %w", err)
+		}
+
+		err = smbReplace(share, tempName, outFileName)
+		if err != nil {
+			_ = share.Remove(tempName)
+			return fmt.Errorf("unable to rename file into place: %w", err)
+		}
+		return nil
+	})
+}
+
+// Checks the settings without connecting to the share
+func (c *CopierSmb) ValidateConfig() error {
+	err := c.OnConflict.validate()
+	if err != nil {
+		return err
+	}
+	return c.PathTemplate.validate()
+}
+
+// Connects to the share and checks the Destination, or the nearest parent it would be created in, is a writable folder
+func (c *CopierSmb) Validate() error {
+	err := c.ValidateConfig()
+	if err != nil {
+		return err
+	}
+	share, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer share.Close()
+
+	destination := smbPath(expandEnv(c.Destination))
+	if destination == "" {
+		return nil
+	}
+	folder, info, err := existingFolder(destination, share.Stat, path.Dir)
+	if err != nil {
+		return fmt.Errorf("invalid destination: %w", err)
+	}
+	return checkWritableMode(folder, info)
+}
+
+type smbConflictTarget struct {
+	share smbFs
+}
+
+func (t *smbConflictTarget) exists(name string) (bool, error) {
+	_, err := t.share.Stat(name)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (t *smbConflictTarget) open(name string) (io.ReadCloser, error) {
+	return t.share.Open(name)
+}
+
+func (c *CopierSmb) GetType() CopierType {
+	return CopierTypeSmb
+}
+
+func (c *CopierSmb) MarshalJSON() ([]byte, error) {
+	type Alias CopierSmb
+	return json.Marshal(&struct {
+		Type CopierType `json:"Type"`
+		*Alias
+	}{
+		Type:  c.GetType(),
+		Alias: (*Alias)(c),
+	})
+}
+
+// Folder of an SMB2/3 share used as a MonitorFolder
+//
+// The URL is of the form "smb://user@server:port/share/folder?domain=DOMAIN&passwordSecret=env:SMB_PASSWORD"
+type sourceSmb struct {
+	share  smbFs
+	folder string // within the share
+}
+
+func openSourceSmb(location *url.URL, secrets *Secrets) (Source, error) {
+	shareName, folder, _ := strings.Cut(strings.TrimPrefix(location.Path, "/"), "/")
+	if shareName == "" {
+		return nil, fmt.Errorf("no share in url: %s", location.Redacted())
+	}
+
+	query := location.Query()
+	var password string
+	if reference := query.Get("passwordSecret"); reference != "" {
+		var err error
+		password, err = secrets.Resolve(reference)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	share, err := dialSmb(location.Host, shareName, query.Get("domain"), location.User.Username(), password)
+	if err != nil {
+		return nil, err
+	}
+
+	source := &sourceSmb{share: share, folder: strings.Trim(folder, "/")}
+	if source.folder != "" {
+		_, err = share.Stat(source.folder)
+		if err != nil {
+			share.Close()
+			return nil, fmt.Errorf("unable to read folder: %w", err)
+		}
+	}
+	return source, nil
+}
+
+func (s *sourceSmb) List() ([]SourceFile, error) {
+	var files []SourceFile
+	err := s.list("", &files)
+	return files, err
+}
+
+func (s *sourceSmb) list(relDir string, files *[]SourceFile) error {
+	entries, err := s.share.ReadDir(path.Join(s.folder, relDir))
+	if err != nil {
+		return fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		relPath := path.Join(relDir, entry.Name())
+		if entry.IsDir() {
+			err = s.list(relPath, files)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		*files = append(*files, SourceFile{Path: relPath, Size: entry.Size(), ModTime: entry.ModTime()})
+	}
+	return nil
+}
+
+func (s *sourceSmb) Open(filePath string) (io.ReadCloser, error) {
+	return s.share.Open(path.Join(s.folder, filePath))
+}
+
+func (s *sourceSmb) Remove(filePath string) error {
+	return s.share.Remove(path.Join(s.folder, filePath))
+}
+
+func (s *sourceSmb) Rename(oldPath, newPath string) error {
+	newPath = path.Join(s.folder, newPath)
+	err := s.share.MkdirAll(path.Dir(newPath), os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("unable to create directory: %w", err)
+	}
+	return smbReplace(s.share, path.Join(s.folder, oldPath), newPath)
+}
+
+func (s *sourceSmb) Close() error {
+	return s.share.Close()
+}
@@ -0,0 +1,272 @@
+package fileMonitor
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// Share backed by a local folder
+//
+// Like SMB it rejects paths with a leading separator and does not replace existing files on rename. Every operation
+// fails once dropped to simulate a connection closed by the server
+type fakeSmb struct {
+	root       string
+	failRename string // renames of this file fail
+	dropped    atomic.Bool
+	closed     atomic.Bool
+}
+
+func (f *fakeSmb) path(name string) (string, error) {
+	if f.dropped.Load() || f.closed.Load() {
+		return "", net.ErrClosed
+	} else if strings.HasPrefix(name, "/") || strings.HasPrefix(name, `\`) {
+		return "", fmt.Errorf("leading separator is not allowed: %s", name)
+	}
+	return filepath.Join(f.root, filepath.FromSlash(name)), nil
+}
+
+func (f *fakeSmb) Stat(name string) (os.FileInfo, error) {
+	filePath, err := f.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(filePath)
+}
+
+func (f *fakeSmb) ReadDir(name string) ([]os.FileInfo, error) {
+	filePath, err := f.path(name)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(filePath)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (f *fakeSmb) Open(name string) (io.ReadCloser, error) {
+	filePath, err := f.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(filePath)
+}
+
+func (f *fakeSmb) Create(name string) (io.WriteCloser, error) {
+	filePath, err := f.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Create(filePath)
+}
+
+func (f *fakeSmb) MkdirAll(name string, perm os.FileMode) error {
+	filePath, err := f.path(name)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(filePath, perm)
+}
+
+func (f *fakeSmb) Remove(name string) error {
+	filePath, err := f.path(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(filePath)
+}
+
+func (f *fakeSmb) Rename(oldPath, newPath string) error {
+	oldFilePath, err := f.path(oldPath)
+	if err != nil {
+		return err
+	}
+	newFilePath, err := f.path(newPath)
+	if err != nil {
+		return err
+	}
+	if oldPath == f.failRename {
+		return fmt.Errorf("rename failed: %s", oldPath)
+	} else if _, err := os.Stat(newFilePath); err == nil {
+		return os.ErrExist
+	}
+	return os.Rename(oldFilePath, newFilePath)
+}
+
+func (f *fakeSmb) Close() error {
+	f.closed.Store(true)
+	return nil
+}
+
+func TestCopierSmb(t *testing.T) {
+	t.Parallel()
+
+	monitorFolder := t.TempDir()
+	filePath := filepath.Join(monitorFolder, "sub", "smb.csv")
+	err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to create sub folder: %v", err)
+	}
+	err = os.WriteFile(filePath, []byte("a,b\n1,2\n"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	root := t.TempDir()
+	var shares []*fakeSmb
+	dialer := func() (smbFs, error) {
+		share := &fakeSmb{root: root}
+		shares = append(shares, share)
+		return share, nil
+	}
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+		if err != nil {
+			return err.Error()
+		}
+		return string(data)
+	}
+
+	// local paths are mapped relative to the root of the share
+	copier := &CopierSmb{Destination: "/dest", dialer: dialer}
+	err = copier.Copy(filePath, monitorFolder)
+	if err != nil {
+		t.Fatalf("failed to copy file: %v", err)
+	}
+	if data := read("dest/sub/smb.csv"); data != "a,b\n1,2\n" {
+		t.Errorf("unexpected copied file: %q", data)
+	}
+
+	// renames do not replace existing files on SMB
+	err = os.WriteFile(filePath, []byte("a,b\n3,4\n"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	err = copier.Copy(filePath, monitorFolder)
+	if err != nil {
+		t.Fatalf("failed to replace file: %v", err)
+	}
+	if data := read("dest/sub/smb.csv"); data != "a,b\n3,4\n" {
+		t.Errorf("expected the existing file to be replaced but got %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(root, "dest", "sub", "smb.csv.part")); !os.IsNotExist(err) {
+		t.Errorf("temporary file should have been renamed: %v", err)
+	}
+	if len(shares) != 1 {
+		t.Errorf("expected the connection to be reused but dialed %d times", len(shares))
+	}
+
+	conflict := &CopierSmb{Destination: "dest", OnConflict: ConflictRenameCounter, dialer: dialer}
+	err = conflict.Copy(filePath, monitorFolder)
+	if err != nil {
+		t.Fatalf("failed to copy conflicting file: %v", err)
+	}
+	if data := read("dest/sub/smb_1.csv"); data != "a,b\n3,4\n" {
+		t.Errorf("expected the conflicting file to be renamed with a counter but got %q", data)
+	}
+	failing := &CopierSmb{Destination: "dest", OnConflict: ConflictFail, dialer: dialer}
+	if err := failing.Copy(filePath, monitorFolder); err == nil || !strings.Contains(err.Error(), ErrDestinationExists.Error()) {
+		t.Errorf("expected an existing file to fail the copy but got: %v", err)
+	}
+
+	templated := &CopierSmb{Destination: "dest", PathTemplate: `{{.RelDir}}/{{.Base}}_copy{{.Ext}}`, dialer: dialer}
+	values, err := newTemplateValues(filePath, monitorFolder)
+	if err != nil {
+		t.Fatalf("failed to get template values: %v", err)
+	}
+	err = templated.CopyTemplated(filePath, monitorFolder, values)
+	if err != nil {
+		t.Fatalf("failed to copy templated file: %v", err)
+	}
+	if data := read("dest/sub/smb_copy.csv"); data != "a,b\n3,4\n" {
+		t.Errorf("expected the file to be copied to the templated path but got %q", data)
+	}
+
+	// connections dropped by the server are replaced
+	dials := len(shares)
+	shares[0].dropped.Store(true)
+	err = copier.Copy(filePath, monitorFolder)
+	if err != nil {
+		t.Errorf("expected the copier to reconnect but got: %v", err)
+	}
+	if len(shares) != dials+1 || !shares[0].closed.Load() {
+		t.Errorf("expected the dropped connection to be closed and replaced but dialed %d times", len(shares)-dials)
+	}
+
+	err = copier.Close()
+	if err != nil {
+		t.Errorf("failed to close copier: %v", err)
+	} else if !shares[len(shares)-1].closed.Load() {
+		t.Errorf("expected closing the copier to close its connection")
+	}
+
+	missing := &CopierSmb{Destination: "missing/nested", dialer: dialer}
+	if err := missing.Validate(); err != nil {
+		t.Errorf("expected a missing destination to be valid but got: %v", err)
+	} else if _, err := os.Stat(filepath.Join(root, "missing")); !os.IsNotExist(err) {
+		t.Errorf("validate should not create the destination: %v", err)
+	}
+}
+
+func TestSmbReplace(t *testing.T) {
+	t.Parallel()
+
+	share := &fakeSmb{root: t.TempDir()}
+	write := func(name, data string) {
+		err := os.WriteFile(filepath.Join(share.root, name), []byte(data), os.ModePerm)
+		if err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(share.root, name))
+		if err != nil {
+			return err.Error()
+		}
+		return string(data)
+	}
+	write("file.csv", "existing")
+	write("file.csv.part", "new")
+
+	// a failed rename keeps the existing file
+	share.failRename = "file.csv.part"
+	if err := smbReplace(share, "file.csv.part", "file.csv"); err == nil {
+		t.Errorf("expected the rename to fail")
+	}
+	if data := read("file.csv"); data != "existing" {
+		t.Errorf("expected the existing file to be restored but got %q", data)
+	}
+	if data := read("file.csv.part"); data != "new" {
+		t.Errorf("expected the new file to be kept but got %q", data)
+	}
+
+	share.failRename = ""
+	err := smbReplace(share, "file.csv.part", "file.csv")
+	if err != nil {
+		t.Fatalf("failed to replace file: %v", err)
+	}
+	if data := read("file.csv"); data != "new" {
+		t.Errorf("expected the existing file to be replaced but got %q", data)
+	}
+	entries, err := os.ReadDir(share.root)
+	if err != nil {
+		t.Fatalf("failed to read share: %v", err)
+	} else if len(entries) != 1 {
+		t.Errorf("expected only the replaced file to be left but found %d files", len(entries))
+	}
+}
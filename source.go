@@ -0,0 +1,303 @@
+package fileMonitor
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Folder within the StagingFolder that remote files are downloaded into before being moved into place
+const downloadFolderName = ".download"
+
+// Remote folder used as the MonitorFolder of a Dir by providing a URL such as "smb://user@server/share/folder"
+//
+// Stable files are downloaded into the StagingFolder and processed like local files. Once processed OnSuccess or
+// OnFailure is applied to the file on the source and the staged copy is removed. ArchiveFolder is then a folder on the
+// source relative to its folder. Files within it are not pulled
+type Source interface {
+	List() ([]SourceFile, error) // Lists all files within the folder including subfolders
+	Open(path string) (io.ReadCloser, error)
+	Remove(path string) error
+	Rename(oldPath, newPath string) error // Creates the folders of newPath and replaces any existing file
+	Close() error
+}
+
+// File listed by a Source
+type SourceFile struct {
+	Path    string // Relative to the folder of the source with "/" as separator
+	Size    int64
+	ModTime time.Time
+}
+
+// Connects to the source at the URL. Secrets are used to resolve references such as the passwordSecret parameter
+type SourceFactory func(location *url.URL, secrets *Secrets) (Source, error)
+
+// Registers the URL scheme of a source so it can be used as a MonitorFolder
+func RegisterSource(scheme string, factory SourceFactory) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	registry.sources[strings.ToLower(scheme)] = factory
+}
+
+func openSource(location string, secrets *Secrets) (Source, error) {
+	sourceUrl, factory, err := parseSource(location)
+	if err != nil {
+		return nil, err
+	}
+	return factory(sourceUrl, secrets)
+}
+
+// Checks the URL and finds the factory of its scheme without connecting
+func parseSource(location string) (*url.URL, SourceFactory, error) {
+	sourceUrl, err := url.Parse(location)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid source url: %w", err)
+	} else if _, ok := sourceUrl.User.Password(); ok {
+		return nil, nil, fmt.Errorf("the password must not be part of the source url. Use the passwordSecret parameter")
+	}
+
+	registry.lock.RLock()
+	factory, ok := registry.sources[strings.ToLower(sourceUrl.Scheme)]
+	registry.lock.RUnlock()
+	if !ok {
+		return nil, nil, fmt.Errorf("no source registered with scheme: %s", sourceUrl.Scheme)
+	}
+	return sourceUrl, factory, nil
+}
+
+// Checks if the MonitorFolder is the URL of a Source
+func (d *Dir) isRemote() bool {
+	return strings.Contains(d.MonitorFolder, "://")
+}
+
+// StagingFolder with environment variables expanded or the default within the temp folder
+func (d *Dir) stagingFolder() string {
+	if d.StagingFolder == "" {
+		return filepath.Join(os.TempDir(), "fileMonitor", d.Name)
+	}
+	return expandEnv(d.StagingFolder)
+}
+
+func (d *Dir) downloadFolder() string {
+	return filepath.Join(d.stagingFolder(), downloadFolderName)
+}
+
+// Checks if path is the claim, download, archive or quarantine folder which are not scanned
+func (d *Dir) isInternalFolder(path string) bool {
+	return d.isClaimFolder(path) || (d.isRemote() && filepath.Clean(path) == d.downloadFolder()) || d.isArchiveFolder(path) ||
+		d.isQuarantineFolder(path)
+}
+
+// Prepares the StagingFolder and removes downloads left unfinished by a previous run
+func (d *Dir) prepareStaging() error {
+	err := os.RemoveAll(d.downloadFolder())
+	if err != nil {
+		return fmt.Errorf("unable to remove unfinished downloads: %w", err)
+	}
+	err = os.MkdirAll(d.downloadFolder(), os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("unable to create staging folder: %w", err)
+	}
+	return nil
+}
+
+// Lists the source every MonitorFrequency and downloads stable files until the dir is stopped
+func (d *Dir) pullSource() {
+	ticker := time.NewTicker(d.MonitorFrequency)
+	defer ticker.Stop()
+	defer d.stopSource()
+
+	for {
+		d.pull()
+		select {
+		case <-ticker.C:
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
+
+// Runs fn with the source connecting to it if needed. The connection is closed after errors so the next call
+// reconnects, and once the dir is stopped
+func (d *Dir) withSource(fn func(source Source) error) error {
+	d.sourceLock.Lock()
+	defer d.sourceLock.Unlock()
+
+	if d.source == nil {
+		source, err := openSource(expandEnv(d.MonitorFolder), d.secrets)
+		if err != nil {
+			return fmt.Errorf("unable to connect to source: %w", err)
+		}
+		d.source = source
+	}
+
+	err := fn(d.source)
+	if err != nil || d.ctx.Err() != nil {
+		d.closeSource()
+	}
+	return err
+}
+
+func (d *Dir) stopSource() {
+	d.sourceLock.Lock()
+	defer d.sourceLock.Unlock()
+	d.closeSource()
+}
+
+// Closes the connection. Must hold sourceLock
+func (d *Dir) closeSource() {
+	if d.source == nil {
+		return
+	}
+	err := d.source.Close()
+	if err != nil {
+		d.log.Warn().Err(err).Msg("failed to close source")
+	}
+	d.source = nil
+}
+
+// Lists the source once, downloads the stable files and schedules them
+func (d *Dir) pull() {
+	if d.IsPaused() {
+		return
+	}
+
+	startList := time.Now()
+	var files []SourceFile
+	err := d.withSource(func(source Source) error {
+		var err error
+		files, err = source.List()
+		return err
+	})
+	if err != nil {
+		d.log.Warn().Err(err).Msg("unable to list source")
+		return
+	}
+	for _, file := range files {
+		if d.ctx.Err() != nil || d.IsPaused() {
+			return
+		}
+		d.pullFile(file)
+	}
+
+	d.pendingLock.Lock()
+	pruneUnseen(d.remotePending, startList)
+	d.storePending()
+	d.pendingLock.Unlock()
+}
+
+// Downloads the file into the StagingFolder if it matches and is stable and schedules it
+//
+// Files already staged are skipped as the file stays on the source until the staged copy has been processed
+func (d *Dir) pullFile(file SourceFile) {
+	fileLog := d.log.With().Str("remotePath", file.Path).Logger()
+	relPath := filepath.FromSlash(file.Path)
+	if !filepath.IsLocal(relPath) {
+		fileLog.Warn().Msg("ignoring file outside of the source folder")
+		return
+	} else if d.isRemoteArchived(file.Path) {
+		return
+	}
+
+	filePath := filepath.Join(d.monitorFolder(), relPath)
+	match, err := d.match(filePath)
+	if err != nil {
+		fileLog.Warn().Err(err).Msg("error matching file")
+		return
+	} else if !match || d.isRenamed(filepath.Base(filePath)) {
+		return
+	}
+
+	if d.isInFlight(filePath) {
+		return
+	} else if _, err := os.Stat(filePath); err == nil {
+		fileLog.Trace().Msg("file is already staged")
+		return
+	} else if d.isLeft(filePath, file.Size, file.ModTime) {
+		return
+	}
+
+	if !d.isRemoteStable(file) {
+		fileLog.Trace().Msg("file is not yet stable")
+		return
+	}
+
+	err = d.withSource(func(source Source) error {
+		return d.download(source, file, filePath)
+	})
+	if err != nil {
+		fileLog.Error().Err(err).Msg("failed to download file")
+		return
+	}
+	fileLog.Trace().Str("filePath", filePath).Msg("downloaded file")
+
+	d.Schedule(filepath.Dir(filePath), filepath.Base(filePath))
+}
+
+// Downloads the file into the download folder and moves it to filePath once complete
+func (d *Dir) download(source Source, file SourceFile, filePath string) error {
+	reader, err := source.Open(file.Path)
+	if err != nil {
+		return fmt.Errorf("unable to open remote file: %w", err)
+	}
+	defer reader.Close()
+
+	tempFile, err := os.CreateTemp(d.downloadFolder(), "download-*")
+	if err != nil {
+		return fmt.Errorf("unable to create temporary file: %w", err)
+	}
+	defer os.Remove(tempFile.Name()) // fails once moved into place
+
+	written, err := io.Copy(tempFile, reader)
+	if err != nil {
+		tempFile.Close()
+		return fmt.Errorf("unable to download file: %w", err)
+	}
+	err = tempFile.Close()
+	if err != nil {
+		return fmt.Errorf("unable to close temporary file: %w", err)
+	} else if written != file.Size {
+		return fmt.Errorf("downloaded %d bytes but expected %d", written, file.Size)
+	}
+
+	// Ignore errors as the modification time is only used by the stability policy
+	_ = os.Chtimes(tempFile.Name(), file.ModTime, file.ModTime)
+
+	err = os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("unable to create directory: %w", err)
+	}
+	err = os.Rename(tempFile.Name(), filePath)
+	if err != nil {
+		return fmt.Errorf("unable to move downloaded file into place: %w", err)
+	}
+	return nil
+}
+
+// Path within the source of a file staged at filePath
+func (d *Dir) remotePath(filePath string) (string, error) {
+	relPath, err := filepath.Rel(d.monitorFolder(), filePath)
+	if err != nil || !filepath.IsLocal(relPath) {
+		return "", fmt.Errorf("file is not within the staging folder: %s", filePath)
+	}
+	return filepath.ToSlash(relPath), nil
+}
+
+// Checks if the path within the source is in the ArchiveFolder of a disposition
+func (d *Dir) isRemoteArchived(remotePath string) bool {
+	for _, disposition := range []*Disposition{&d.OnSuccess, &d.OnFailure} {
+		if disposition.Action != DispositionArchive || disposition.ArchiveFolder == "" {
+			continue
+		}
+		archiveFolder := path.Clean(filepath.ToSlash(expandEnv(disposition.ArchiveFolder)))
+		if strings.HasPrefix(remotePath, strings.TrimPrefix(archiveFolder, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
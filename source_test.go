@@ -0,0 +1,243 @@
+package fileMonitor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/treavorj/zerolog"
+	"github.com/treavorj/zerolog/log"
+	"github.com/treavorj/zerolog/pkgerrors"
+)
+
+// In-process stand-in for a remote folder. Registered with the "memory" scheme using the host as its name
+type memorySource struct {
+	lock    sync.Mutex
+	files   map[string][]byte
+	modTime time.Time
+}
+
+var memorySources sync.Map
+
+func init() {
+	RegisterSource("memory", func(location *url.URL, secrets *Secrets) (Source, error) {
+		source, ok := memorySources.Load(location.Host)
+		if !ok {
+			return nil, fmt.Errorf("no memory source named %s", location.Host)
+		}
+		return source.(*memorySource), nil
+	})
+}
+
+func (s *memorySource) List() ([]SourceFile, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	files := make([]SourceFile, 0, len(s.files))
+	for filePath, data := range s.files {
+		files = append(files, SourceFile{Path: filePath, Size: int64(len(data)), ModTime: s.modTime})
+	}
+	return files, nil
+}
+
+func (s *memorySource) Open(filePath string) (io.ReadCloser, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	data, ok := s.files[filePath]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *memorySource) Remove(filePath string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.files, filePath)
+	return nil
+}
+
+func (s *memorySource) Rename(oldPath, newPath string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	data, ok := s.files[oldPath]
+	if !ok {
+		return os.ErrNotExist
+	}
+	delete(s.files, oldPath)
+	s.files[newPath] = data
+	return nil
+}
+
+func (s *memorySource) Close() error {
+	return nil
+}
+
+func (s *memorySource) has(filePath string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, ok := s.files[filePath]
+	return ok
+}
+
+func TestSource(t *testing.T) {
+	t.Parallel()
+
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+	logger := log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339Nano}).With().Caller().Logger()
+
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(configFile, []byte("{}"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	fileMonitor, err := NewFileMonitor(context.Background(), logger, configFile)
+	if err != nil {
+		t.Fatalf("failed to create fileMonitor: %v", err)
+	}
+
+	_, err = openSource("memory://user:password@"+t.Name(), fileMonitor.secrets)
+	if err == nil || !strings.Contains(err.Error(), "password") {
+		t.Errorf("expected a password in the url to be rejected but got: %v", err)
+	}
+
+	source := &memorySource{
+		files: map[string][]byte{
+			"sub/remote.csv": []byte("a,b\n1,2\n"),
+			"ignored.txt":    []byte("ignored"),
+		},
+		modTime: time.Now(),
+	}
+	memorySources.Store(t.Name(), source)
+
+	stagingFolder := t.TempDir()
+	destination := t.TempDir()
+	dir := &Dir{
+		Name:             t.Name(),
+		MonitorFolder:    "memory://" + t.Name() + "/",
+		StagingFolder:    stagingFolder,
+		MonitorFrequency: time.Millisecond * 50,
+		MatchGroups:      []MatchGroup{{Expression: `\.csv$`}},
+		Stability:        Stability{Observations: 2},
+		Copiers:          []Copier{&CopierLocal{Destination: destination}},
+	}
+	if errs := dir.Validate(); len(errs) != 0 {
+		t.Fatalf("expected the remote dir to be valid: %v", errs)
+	}
+	err = fileMonitor.AddDir(dir)
+	if err != nil {
+		t.Fatalf("failed to add dir: %v", err)
+	}
+
+	time.Sleep(time.Millisecond * 500)
+	data, err := os.ReadFile(filepath.Join(destination, "sub", "remote.csv"))
+	if err != nil {
+		t.Fatalf("remote file should have been copied: %v", err)
+	} else if string(data) != "a,b\n1,2\n" {
+		t.Errorf("unexpected content of copied file: %q", data)
+	}
+
+	if source.has("sub/remote.csv") {
+		t.Errorf("remote file should have been removed from the source")
+	}
+	if !source.has("ignored.txt") {
+		t.Errorf("file not matching should have been left on the source")
+	}
+	if _, err := os.Stat(filepath.Join(stagingFolder, "sub", "remote.csv")); !os.IsNotExist(err) {
+		t.Errorf("staged file should have been deleted: %v", err)
+	}
+}
+
+// Runs against a real server such as a local Samba container when FILEMONITOR_TEST_SMB is set to the URL of a
+// writable folder, e.g. "smb://user@localhost/share/folder?passwordSecret=env:SMB_PASSWORD"
+func TestSmb(t *testing.T) {
+	location := os.Getenv("FILEMONITOR_TEST_SMB")
+	if location == "" {
+		t.Skip("FILEMONITOR_TEST_SMB is not set")
+	}
+	t.Parallel()
+
+	smbUrl, err := url.Parse(location)
+	if err != nil {
+		t.Fatalf("invalid url: %v", err)
+	}
+	shareName, folder, _ := strings.Cut(strings.TrimPrefix(smbUrl.Path, "/"), "/")
+	copier := &CopierSmb{
+		Server:         smbUrl.Host,
+		Share:          shareName,
+		Domain:         smbUrl.Query().Get("domain"),
+		Username:       smbUrl.User.Username(),
+		PasswordSecret: smbUrl.Query().Get("passwordSecret"),
+		Destination:    path.Join(folder, t.Name()),
+	}
+	copier.UseSecrets(newSecrets())
+
+	monitorFolder := t.TempDir()
+	filePath := filepath.Join(monitorFolder, "sub", "smb.csv")
+	err = os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to create sub folder: %v", err)
+	}
+	err = os.WriteFile(filePath, []byte("a,b\n1,2\n"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	err = copier.Validate()
+	if err != nil {
+		t.Fatalf("failed to validate copier: %v", err)
+	}
+	err = copier.Copy(filePath, monitorFolder)
+	if err != nil {
+		t.Fatalf("failed to copy file: %v", err)
+	}
+
+	source, err := openSource(location, newSecrets())
+	if err != nil {
+		t.Fatalf("failed to open source: %v", err)
+	}
+	defer source.Close()
+
+	files, err := source.List()
+	if err != nil {
+		t.Fatalf("failed to list source: %v", err)
+	}
+	remotePath := path.Join(t.Name(), "sub", "smb.csv")
+	found := false
+	for _, file := range files {
+		found = found || (file.Path == remotePath && file.Size == 8)
+	}
+	if !found {
+		t.Fatalf("copied file %s not listed: %+v", remotePath, files)
+	}
+
+	reader, err := source.Open(remotePath)
+	if err != nil {
+		t.Fatalf("failed to open remote file: %v", err)
+	}
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil || string(data) != "a,b\n1,2\n" {
+		t.Errorf("unexpected content of remote file: %q, %v", data, err)
+	}
+
+	err = source.Remove(remotePath)
+	if err != nil {
+		t.Errorf("failed to remove remote file: %v", err)
+	}
+}
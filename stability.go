@@ -0,0 +1,138 @@
+package fileMonitor
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Policy deciding when a file is no longer being written and can be processed
+//
+// All enabled checks must pass. The zero value processes files as soon as they are seen
+type Stability struct {
+	Observations uint          // Consecutive observations (including the first) with an unchanged size and modification time
+	MinAge       time.Duration // Minimum time since the file was last written
+	LockProbe    bool          // File must be able to be opened exclusively. On linux only detects advisory locks
+}
+
+func (s *Stability) enabled() bool {
+	return s.Observations > 1 || s.MinAge > 0 || s.LockProbe
+}
+
+type pendingFile struct {
+	size         int64
+	modTime      time.Time
+	observations uint
+	lastSeen     time.Time
+}
+
+// Observes the file and returns true once it satisfies the Stability policy
+//
+// Files that are not stable are held as pending until they are observed again
+func (d *Dir) isStable(filePath string) (bool, error) {
+	if !d.Stability.enabled() {
+		return true, nil
+	}
+
+	fileStats, err := os.Stat(filePath)
+	if err != nil {
+		d.pendingLock.Lock()
+		delete(d.pending, filePath)
+		d.storePending()
+		d.pendingLock.Unlock()
+		return false, err
+	}
+
+	d.pendingLock.Lock()
+	defer d.pendingLock.Unlock()
+	if d.pending == nil {
+		d.pending = make(map[string]*pendingFile)
+	}
+
+	stable := d.Stability.observe(d.pending, filePath, fileStats.Size(), fileStats.ModTime())
+	if stable && d.Stability.LockProbe {
+		err = probeExclusive(filePath)
+		if err != nil {
+			d.log.Trace().Err(err).Str("filePath", filePath).Msg("file is locked")
+			stable = false
+		}
+	}
+
+	if stable {
+		delete(d.pending, filePath)
+	}
+	d.storePending()
+	return stable, nil
+}
+
+// Observes a file listed by the Source using its listed size and modification time. LockProbe does not apply
+func (d *Dir) isRemoteStable(file SourceFile) bool {
+	if !d.Stability.enabled() {
+		return true
+	}
+
+	d.pendingLock.Lock()
+	defer d.pendingLock.Unlock()
+	if d.remotePending == nil {
+		d.remotePending = make(map[string]*pendingFile)
+	}
+
+	stable := d.Stability.observe(d.remotePending, file.Path, file.Size, file.ModTime)
+	if stable {
+		delete(d.remotePending, file.Path)
+	}
+	d.storePending()
+	return stable
+}
+
+// Records an observation of the file in pending and checks the Observations and MinAge of the policy
+func (s *Stability) observe(pending map[string]*pendingFile, key string, size int64, modTime time.Time) bool {
+	file, ok := pending[key]
+	if !ok || file.size != size || !file.modTime.Equal(modTime) {
+		file = &pendingFile{
+			size:    size,
+			modTime: modTime,
+		}
+		pending[key] = file
+	}
+	file.observations++
+	file.lastSeen = time.Now()
+
+	return file.observations >= s.Observations && time.Since(modTime) >= s.MinAge
+}
+
+// Updates the Pending stat. Must be called with pendingLock held
+func (d *Dir) storePending() {
+	d.Stats.Pending.Store(uint64(len(d.pending) + len(d.remotePending)))
+}
+
+// Observes all pending files again and schedules those which are now stable
+func (d *Dir) RecheckPending() {
+	d.pendingLock.Lock()
+	filePaths := make([]string, 0, len(d.pending))
+	for filePath := range d.pending {
+		filePaths = append(filePaths, filePath)
+	}
+	d.pendingLock.Unlock()
+
+	for _, filePath := range filePaths {
+		d.Schedule(filepath.Dir(filePath), filepath.Base(filePath))
+	}
+}
+
+// Removes pending files which were not seen since the given time
+func (d *Dir) prunePending(since time.Time) {
+	d.pendingLock.Lock()
+	defer d.pendingLock.Unlock()
+
+	pruneUnseen(d.pending, since)
+	d.storePending()
+}
+
+func pruneUnseen(pending map[string]*pendingFile, since time.Time) {
+	for key, file := range pending {
+		if file.lastSeen.Before(since) {
+			delete(pending, key)
+		}
+	}
+}
@@ -9,29 +9,46 @@ import (
 type Stats struct {
 	TotalFiles atomic.Uint64
 	TotalBytes atomic.Uint64
+	Pending    atomic.Uint64 // Files waiting to become stable
+
+	SkippedInFlight atomic.Uint64 // Files seen again while already scheduled or being processed
+	Duplicates      atomic.Uint64 // Files skipped or flagged as already completed by the ledger
+	Retrying        atomic.Uint64 // Files parked waiting for a retry
 }
 
 // Adds the stats from the other stats
 func (s *Stats) Add(other *Stats) {
 	s.TotalFiles.Add(other.TotalFiles.Load())
 	s.TotalBytes.Add(other.TotalBytes.Load())
+	s.Pending.Add(other.Pending.Load())
+	s.SkippedInFlight.Add(other.SkippedInFlight.Load())
+	s.Duplicates.Add(other.Duplicates.Load())
+	s.Retrying.Add(other.Retrying.Load())
 }
 
 // Subtracts the stats from the other stats
 func (s *Stats) Sub(other *Stats) {
 	s.TotalFiles.Add(-other.TotalFiles.Load())
 	s.TotalBytes.Add(-other.TotalBytes.Load())
+	s.Pending.Add(-other.Pending.Load())
+	s.SkippedInFlight.Add(-other.SkippedInFlight.Load())
+	s.Duplicates.Add(-other.Duplicates.Load())
+	s.Retrying.Add(-other.Retrying.Load())
 }
 
 // Resets the stats
 func (s *Stats) Reset() {
 	s.TotalFiles.Store(0)
 	s.TotalBytes.Store(0)
+	s.Pending.Store(0)
+	s.SkippedInFlight.Store(0)
+	s.Duplicates.Store(0)
+	s.Retrying.Store(0)
 }
 
 // String representation of the stats
 func (s *Stats) String() string {
-	return fmt.Sprintf("Files: %d | Bytes: %d", s.TotalFiles.Load(), s.TotalBytes.Load())
+	return fmt.Sprintf("Files: %d | Bytes: %d | Pending: %d | SkippedInFlight: %d | Duplicates: %d | Retrying: %d", s.TotalFiles.Load(), s.TotalBytes.Load(), s.Pending.Load(), s.SkippedInFlight.Load(), s.Duplicates.Load(), s.Retrying.Load())
 }
 
 // Increments the stats
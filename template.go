@@ -0,0 +1,174 @@
+package fileMonitor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Path of a copied file within the Destination written as a Go text/template executed with TemplateValues such as
+// `{{.ModTime.Format "2006/01/02"}}/{{.Groups.instrument}}/{{.Name}}`
+//
+// The path relative to the MonitorFolder is kept if empty. Paths leaving the Destination are rejected
+type PathTemplate string
+
+// Values of the file being copied available to a PathTemplate
+type TemplateValues struct {
+	Dir     string            // Name of the Dir
+	RelDir  string            // Folder of the file relative to the MonitorFolder using "/". Empty at the top level
+	Name    string            // File name with the extension
+	Base    string            // File name without the extension
+	Ext     string            // Extension including the dot such as ".csv"
+	ModTime time.Time         // Modification time of the file
+	Now     time.Time         // Time the file started processing
+	Groups  map[string]string // Named groups such as (?P<instrument>[a-z]+) captured by the MatchGroups
+	Ids     []string          // Ids returned by the Processor
+
+	filePath string
+	hash     string
+}
+
+// Copiers supporting a PathTemplate implement this to receive the values of the file being copied
+type TemplateCopier interface {
+	CopyTemplated(inFilePath, monitorDir string, values *TemplateValues) error
+}
+
+// Values known from the file alone. The Dir adds its name, the groups and the ids
+func newTemplateValues(inFilePath, monitorDir string) (*TemplateValues, error) {
+	fileStats, err := os.Stat(inFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to stat file: %w", err)
+	}
+
+	relPath := filepath.ToSlash(getOutFileName(inFilePath, monitorDir, ""))
+	relDir := strings.Trim(filepath.ToSlash(filepath.Dir(relPath)), "/")
+	if relDir == "." {
+		relDir = ""
+	}
+	name := filepath.Base(inFilePath)
+	ext := filepath.Ext(name)
+	return &TemplateValues{
+		RelDir:   relDir,
+		Name:     name,
+		Base:     strings.TrimSuffix(name, ext),
+		Ext:      ext,
+		ModTime:  fileStats.ModTime(),
+		Now:      time.Now(),
+		Groups:   map[string]string{},
+		filePath: inFilePath,
+	}, nil
+}
+
+// SHA-256 of the content in hex. Only hashed when used by a template
+func (v *TemplateValues) Hash() (string, error) {
+	if v.hash != "" {
+		return v.hash, nil
+	}
+	hash, err := hashFile(v.filePath)
+	if err != nil {
+		return "", fmt.Errorf("unable to hash file: %w", err)
+	}
+	v.hash = hash
+	return hash, nil
+}
+
+func (t PathTemplate) parse() (*template.Template, error) {
+	parsed, err := template.New("path").Option("missingkey=error").Parse(string(t))
+	if err != nil {
+		return nil, fmt.Errorf("invalid path template: %w", err)
+	}
+	return parsed, nil
+}
+
+func (t PathTemplate) validate() error {
+	_, err := t.parse()
+	return err
+}
+
+// Joins destination and the path given by the template or relative to monitorDir if the template is empty
+//
+// Values are taken from the file alone if nil
+func (t PathTemplate) outFileName(inFilePath, monitorDir, destination string, values *TemplateValues) (string, error) {
+	if t == "" {
+		return getOutFileName(inFilePath, monitorDir, destination), nil
+	}
+
+	parsed, err := t.parse()
+	if err != nil {
+		return "", err
+	}
+	if values == nil {
+		values, err = newTemplateValues(inFilePath, monitorDir)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var builder strings.Builder
+	err = parsed.Execute(&builder, values)
+	if err != nil {
+		return "", fmt.Errorf("unable to execute path template: %w", err)
+	}
+	relPath := filepath.Clean(filepath.FromSlash(strings.TrimLeft(builder.String(), "/")))
+	if !filepath.IsLocal(relPath) {
+		return "", fmt.Errorf("path template gave a path outside of the destination: %s", builder.String())
+	}
+	return filepath.Join(destination, relPath), nil
+}
+
+// Values of the file for the copiers of the dir
+//
+// Groups are captured from originalPath as the MatchGroups are checked against it before the file is claimed
+func (d *Dir) templateValues(originalPath, inFilePath, monitorFolder string, started time.Time, ids []string) (*TemplateValues, error) {
+	values, err := newTemplateValues(inFilePath, monitorFolder)
+	if err != nil {
+		return nil, err
+	}
+	values.Dir = d.Name
+	values.Now = started
+	values.Ids = ids
+
+	name := filepath.ToSlash(originalPath)
+	for _, matchGroup := range d.MatchGroups {
+		if matchGroup.Exclude {
+			continue
+		}
+		_, err = matchGroup.Match(originalPath) // compiles the expression
+		if err != nil {
+			return nil, err
+		}
+		submatches := matchGroup.compiled.FindStringSubmatch(name)
+		for i, group := range matchGroup.compiled.SubexpNames() {
+			if group != "" && i < len(submatches) {
+				values.Groups[group] = submatches[i]
+			}
+		}
+	}
+	return values, nil
+}
+
+// Values of the task for its copiers
+func (d *Dir) taskTemplateValues(task *fileTask) (*TemplateValues, error) {
+	var ids []string
+	var hash string
+	if task.record != nil {
+		ids, hash = task.record.Ids, task.record.Hash
+	}
+	values, err := d.templateValues(task.originalPath(), task.filePath, task.monitorFolder, task.started, ids)
+	if err != nil {
+		return nil, err
+	}
+	values.hash = hash // already known if the ledger is enabled
+	return values, nil
+}
+
+// Copies the file passing the template values to copiers supporting them
+func copyTemplated(copier Copier, inFilePath, monitorFolder string, values *TemplateValues) error {
+	if templated, ok := copier.(TemplateCopier); ok && values != nil {
+		return templated.CopyTemplated(inFilePath, monitorFolder, values)
+	}
+	return copier.Copy(inFilePath, monitorFolder)
+}
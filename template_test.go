@@ -0,0 +1,121 @@
+package fileMonitor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/treavorj/zerolog"
+	"github.com/treavorj/zerolog/log"
+	"github.com/treavorj/zerolog/pkgerrors"
+)
+
+func TestPathTemplate(t *testing.T) {
+	t.Parallel()
+
+	monitorFolder := t.TempDir()
+	filePath := filepath.Join(monitorFolder, "sub", "run.csv")
+	err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to create sub folder: %v", err)
+	}
+	err = os.WriteFile(filePath, []byte("a,b\n"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	modTime := time.Date(2024, 3, 5, 10, 0, 0, 0, time.Local)
+	err = os.Chtimes(filePath, modTime, modTime)
+	if err != nil {
+		t.Fatalf("failed to change times: %v", err)
+	}
+	hash, err := hashFile(filePath)
+	if err != nil {
+		t.Fatalf("failed to hash file: %v", err)
+	}
+
+	destination := t.TempDir()
+	tests := []struct {
+		template PathTemplate
+		expected string
+	}{
+		{"", filepath.Join(destination, "sub", "run.csv")},
+		{`{{.ModTime.Format "2006/01/02"}}/{{.Name}}`, filepath.Join(destination, "2024", "03", "05", "run.csv")},
+		{`{{.RelDir}}/{{.Base}}_copy{{.Ext}}`, filepath.Join(destination, "sub", "run_copy.csv")},
+		{`/{{.Hash}}{{.Ext}}`, filepath.Join(destination, hash+".csv")},
+	}
+	for _, test := range tests {
+		outFileName, err := test.template.outFileName(filePath, monitorFolder, destination, nil)
+		if err != nil || outFileName != test.expected {
+			t.Errorf("template %q: expected %s but got %s, %v", test.template, test.expected, outFileName, err)
+		}
+	}
+
+	for _, template := range []PathTemplate{`../{{.Name}}`, `{{.Groups.missing}}/{{.Name}}`} {
+		_, err = template.outFileName(filePath, monitorFolder, destination, nil)
+		if err == nil {
+			t.Errorf("template %q should have failed", template)
+		}
+	}
+	if PathTemplate(`{{.Name`).validate() == nil {
+		t.Errorf("expected an invalid template to fail validation")
+	}
+}
+
+type idExecutor struct{}
+
+func (idExecutor) Process(filePath string) ([][]byte, []string, error) {
+	return nil, []string{"run42"}, nil
+}
+
+func TestPathTemplateDir(t *testing.T) {
+	t.Parallel()
+
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+	logger := log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339Nano}).With().Caller().Logger()
+
+	monitorFolder := t.TempDir()
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(configFile, []byte("{}"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	fileMonitor, err := NewFileMonitor(context.Background(), logger, configFile)
+	if err != nil {
+		t.Fatalf("failed to create fileMonitor: %v", err)
+	} else if fileMonitor == nil {
+		t.Fatalf("fileMonitor is nil")
+	}
+
+	destination := t.TempDir()
+	dir := &Dir{
+		Name:             "instruments",
+		MonitorFolder:    monitorFolder,
+		MonitorFrequency: time.Millisecond * 50,
+		MatchGroups:      []MatchGroup{{Expression: `(?P<instrument>[a-z]+)_\d+\.csv$`}},
+		Processor:        &Processor{Executor: idExecutor{}},
+		Copiers: []Copier{&CopierLocal{
+			Destination:  destination,
+			PathTemplate: `{{.Dir}}/{{.Now.Format "2006/01"}}/{{.Groups.instrument}}/{{index .Ids 0}}{{.Ext}}`,
+		}},
+	}
+	err = fileMonitor.AddDir(dir)
+	if err != nil {
+		t.Fatalf("failed to add dir: %v", err)
+	}
+
+	err = os.WriteFile(filepath.Join(monitorFolder, "spectrometer_1.csv"), []byte("a,b\n"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	time.Sleep(time.Millisecond * 500)
+	outFileName := filepath.Join(destination, "instruments", time.Now().Format("2006"), time.Now().Format("01"), "spectrometer", "run42.csv")
+	_, err = os.Stat(outFileName)
+	if err != nil {
+		t.Errorf("file should have been copied to the templated path: %v", err)
+	}
+}
@@ -0,0 +1,288 @@
+package fileMonitor
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Timeout when checking remote copier destinations are reachable
+const validateTimeout = time.Second * 10
+
+// Problem found in the config
+type ValidationError struct {
+	Dir   string // Name of the dir. Empty if the problem is with the FileMonitor
+	Field string // Path of the field such as "MatchGroups[0].Expression"
+	Err   error
+}
+
+func (e *ValidationError) Error() string {
+	if e.Dir == "" {
+		return fmt.Sprintf("%s: %v", e.Field, e.Err)
+	}
+	return fmt.Sprintf("dir %s: %s: %v", e.Dir, e.Field, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// All problems found in the config
+type ValidationErrors []*ValidationError
+
+func (v ValidationErrors) Error() string {
+	messages := make([]string, len(v))
+	for n, err := range v {
+		messages[n] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (v ValidationErrors) Unwrap() []error {
+	errs := make([]error, len(v))
+	for n, err := range v {
+		errs[n] = err
+	}
+	return errs
+}
+
+// Copiers can implement this to have their destination checked by Dir.Validate
+type CopierValidator interface {
+	Validate() error
+}
+
+// Copiers can implement this to have their settings checked without connecting to the destination, such as when the
+// config is reloaded
+type CopierConfigValidator interface {
+	ValidateConfig() error
+}
+
+// Checks the config of the FileMonitor and all of its dirs without applying it
+func (f *FileMonitor) Validate() ValidationErrors {
+	return f.validate(true)
+}
+
+// Checks the config of the FileMonitor and its dirs. Remote sources and copier destinations are only connected to if
+// reachable is set
+func (f *FileMonitor) validate(reachable bool) ValidationErrors {
+	var errs ValidationErrors
+	add := func(field string, format string, args ...any) {
+		errs = append(errs, &ValidationError{Field: field, Err: fmt.Errorf(format, args...)})
+	}
+
+	if f.ReloadFrequency < 0 {
+		add("ReloadFrequency", "must not be negative: %v", f.ReloadFrequency)
+	}
+
+	names := make(map[string]string)
+	for _, key := range slices.Sorted(maps.Keys(f.Dirs)) {
+		dir := f.Dirs[key]
+		if dir == nil {
+			add("Dirs."+key, "dir is empty")
+			continue
+		}
+		if dir.Name != key {
+			add("Dirs."+key, "key does not match the dir name %s", dir.Name)
+		}
+		if other, ok := names[dir.Name]; ok {
+			add("Dirs."+key, "duplicate name %s also used by %s", dir.Name, other)
+		}
+		names[dir.Name] = key
+
+		dir.useSecrets(f.secrets)
+		errs = append(errs, dir.validate(reachable)...)
+	}
+	return errs
+}
+
+// Checks the config of the dir without applying it
+//
+// Regexes are compiled, folders must exist and be readable and copier destinations must be writable or reachable
+func (d *Dir) Validate() ValidationErrors {
+	return d.validate(true)
+}
+
+func (d *Dir) validate(reachable bool) ValidationErrors {
+	var errs ValidationErrors
+	add := func(field string, err error) {
+		errs = append(errs, &ValidationError{Dir: d.Name, Field: field, Err: err})
+	}
+
+	if d.Name == "" {
+		add("Name", fmt.Errorf("must not be empty"))
+	}
+	if d.MonitorFrequency <= 0 {
+		add("MonitorFrequency", fmt.Errorf("must be greater than 0: %v", d.MonitorFrequency))
+	}
+	if d.WatcherType < WatcherTypePoll || d.WatcherType > WatcherTypeNotify {
+		add("WatcherType", fmt.Errorf("invalid watcher type: %d", d.WatcherType))
+	}
+
+	if d.MonitorFolder == "" {
+		add("MonitorFolder", fmt.Errorf("must not be empty"))
+	} else if d.isRemote() && !reachable {
+		_, _, err := parseSource(expandEnv(d.MonitorFolder))
+		if err != nil {
+			add("MonitorFolder", err)
+		}
+	} else if d.isRemote() {
+		source, err := openSource(expandEnv(d.MonitorFolder), d.secrets)
+		if err != nil {
+			add("MonitorFolder", fmt.Errorf("unable to open source: %w", err))
+		} else {
+			source.Close()
+		}
+	} else if _, err := os.ReadDir(d.monitorFolder()); err != nil {
+		add("MonitorFolder", fmt.Errorf("unable to read folder: %w", err))
+	}
+
+	for n, matchGroup := range d.MatchGroups {
+		_, err := regexp.Compile(matchGroup.Expression)
+		if err != nil {
+			add(fmt.Sprintf("MatchGroups[%d].Expression", n), err)
+		}
+	}
+
+	if d.Stability.MinAge < 0 {
+		add("Stability.MinAge", fmt.Errorf("must not be negative: %v", d.Stability.MinAge))
+	}
+	if d.Recovery < RecoveryPolicyRetry || d.Recovery > RecoveryPolicyLeave {
+		add("Recovery", fmt.Errorf("invalid recovery policy: %d", d.Recovery))
+	}
+	if d.Duplicates < DuplicatePolicyProcess || d.Duplicates > DuplicatePolicyFlag {
+		add("Duplicates", fmt.Errorf("invalid duplicate policy: %d", d.Duplicates))
+	}
+
+	if d.Retry.Backoff < 0 {
+		add("Retry.Backoff", fmt.Errorf("must not be negative: %v", d.Retry.Backoff))
+	}
+	if d.Retry.MaxBackoff < 0 {
+		add("Retry.MaxBackoff", fmt.Errorf("must not be negative: %v", d.Retry.MaxBackoff))
+	}
+	if d.Retry.Jitter < 0 || d.Retry.Jitter > 1 {
+		add("Retry.Jitter", fmt.Errorf("must be between 0 and 1: %v", d.Retry.Jitter))
+	}
+	for n, expression := range d.Retry.RetryableErrors {
+		_, err := regexp.Compile(expression)
+		if err != nil {
+			add(fmt.Sprintf("Retry.RetryableErrors[%d]", n), err)
+		}
+	}
+
+	dispositions := []struct {
+		field       string
+		disposition *Disposition
+	}{{"OnSuccess", &d.OnSuccess}, {"OnFailure", &d.OnFailure}}
+	for _, entry := range dispositions {
+		field, disposition := entry.field, entry.disposition
+		if disposition.Action < DispositionDelete || disposition.Action > DispositionLeave {
+			add(field+".Action", fmt.Errorf("invalid disposition action: %d", disposition.Action))
+		} else if disposition.Action == DispositionArchive && disposition.ArchiveFolder == "" {
+			add(field+".ArchiveFolder", fmt.Errorf("must not be empty when archiving"))
+		}
+	}
+
+	copierGroups := []struct {
+		field   string
+		copiers []Copier
+	}{{"Copiers", d.Copiers}, {"ErrorCopiers", d.ErrorCopiers}}
+	for _, entry := range copierGroups {
+		field, copiers := entry.field, entry.copiers
+		for n, copier := range copiers {
+			if copier == nil {
+				add(fmt.Sprintf("%s[%d]", field, n), fmt.Errorf("copier is empty"))
+				continue
+			}
+			var err error
+			if validator, ok := copier.(CopierValidator); ok && reachable {
+				err = validator.Validate()
+			} else if validator, ok := copier.(CopierConfigValidator); ok {
+				err = validator.ValidateConfig()
+			}
+			if err != nil {
+				add(fmt.Sprintf("%s[%d]", field, n), err)
+			}
+		}
+	}
+	return errs
+}
+
+// Reads the config file and validates it without starting anything. Used to check a config before it is applied
+func ValidateConfigFile(configPath string) (ValidationErrors, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config file: %w", err)
+	}
+
+	fileMonitor := FileMonitor{secrets: newSecrets()}
+	err = configFormatOf(configPath).unmarshal(data, &fileMonitor)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unmarshal config file: %w", err)
+	}
+	return fileMonitor.Validate(), nil
+}
+
+// Local destinations are checked the same way as they do not need a connection
+func (c *CopierLocal) ValidateConfig() error {
+	return c.Validate()
+}
+
+// Checks the destination, or the nearest parent it would be created in, is a writable folder without creating anything
+func (c *CopierLocal) Validate() error {
+	if c.Destination == "" {
+		return fmt.Errorf("destination must not be empty")
+	}
+	err := c.OnConflict.validate()
+	if err != nil {
+		return err
+	}
+	err = c.PathTemplate.validate()
+	if err != nil {
+		return err
+	}
+
+	folder, _, err := existingFolder(expandEnv(c.Destination), os.Stat, filepath.Dir)
+	if err != nil {
+		return fmt.Errorf("invalid destination: %w", err)
+	}
+	err = checkWritable(folder)
+	if err != nil {
+		return fmt.Errorf("destination is not writable: %w", err)
+	}
+	return nil
+}
+
+// Finds the folder or its nearest parent which exists as destinations are only created by the first copy
+func existingFolder(folder string, stat func(string) (os.FileInfo, error), parent func(string) string) (string, os.FileInfo, error) {
+	for {
+		info, err := stat(folder)
+		if err == nil {
+			if !info.IsDir() {
+				return "", nil, fmt.Errorf("%s is not a folder", folder)
+			}
+			return folder, info, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", nil, fmt.Errorf("unable to stat %s: %w", folder, err)
+		}
+
+		next := parent(folder)
+		if next == folder {
+			return "", nil, fmt.Errorf("no parent of %s exists", folder)
+		}
+		folder = next
+	}
+}
+
+// Checks the permission bits of a remote folder as the user the server maps the login to is not known
+func checkWritableMode(folder string, info os.FileInfo) error {
+	if info.Mode().Perm()&0222 == 0 {
+		return fmt.Errorf("%s is read only", folder)
+	}
+	return nil
+}
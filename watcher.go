@@ -0,0 +1,49 @@
+package fileMonitor
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type WatcherType int
+
+const (
+	WatcherTypePoll   WatcherType = iota // Rescans the entire folder every MonitorFrequency. Use for network mounts
+	WatcherTypeNotify                    // Uses OS file notifications (inotify on linux) and only rescans if events are lost. Pending files are rechecked every MonitorFrequency
+)
+
+// Discovers files within a Dir and schedules them for processing
+//
+// Implementations call Dir.Schedule for individual files and Dir.Rescan for a full walk of the folder
+type Watcher interface {
+	Watch(ctx context.Context, dir *Dir) error // Must block until ctx is done
+}
+
+func (w WatcherType) newWatcher() (Watcher, error) {
+	switch w {
+	case WatcherTypePoll:
+		return &watcherPoll{}, nil
+	case WatcherTypeNotify:
+		return newWatcherNotify()
+	default:
+		return nil, fmt.Errorf("no valid watcher for %v", w)
+	}
+}
+
+// Polls the folder using a ticker
+type watcherPoll struct{}
+
+func (w *watcherPoll) Watch(ctx context.Context, dir *Dir) error {
+	ticker := time.NewTicker(dir.MonitorFrequency)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			_ = dir.Rescan()
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
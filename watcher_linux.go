@@ -0,0 +1,172 @@
+//go:build linux
+
+package fileMonitor
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const notifyMask = unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_MOVED_FROM | unix.IN_CREATE | unix.IN_DELETE
+
+// Recursive inotify watcher
+//
+// Files are scheduled once they are closed after writing or moved into the folder.
+// If the kernel queue overflows a full rescan is performed
+type watcherNotify struct {
+	fd      int
+	file    *os.File
+	watches map[int]string // watch descriptor to dir path
+}
+
+func newWatcherNotify() (Watcher, error) {
+	return &watcherNotify{}, nil
+}
+
+func (w *watcherNotify) Watch(ctx context.Context, dir *Dir) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return fmt.Errorf("failed to initialize inotify: %w", err)
+	}
+	w.fd = fd
+	w.file = os.NewFile(uintptr(fd), "inotify") // non-blocking so Close interrupts Read
+	w.watches = make(map[int]string)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer w.file.Close()
+
+		// events are only received once so pending files must be observed again on a timer
+		ticker := time.NewTicker(dir.MonitorFrequency)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				dir.RecheckPending()
+				dir.removeEmptyDirs()
+			case <-ctx.Done():
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	err = w.addRecursive(dir, dir.monitorFolder())
+	if err != nil {
+		return err
+	}
+	_ = dir.Rescan() // pick up files that existed before the watches were added
+
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to read inotify events: %w", err)
+		}
+
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			start := offset + unix.SizeofInotifyEvent
+			offset = start + int(event.Len)
+			if offset > n {
+				break
+			}
+			name := strings.TrimRight(string(buf[start:offset]), "\x00")
+			w.handleEvent(dir, int(event.Wd), event.Mask, name)
+		}
+	}
+}
+
+func (w *watcherNotify) handleEvent(dir *Dir, wd int, mask uint32, name string) {
+	if mask&unix.IN_Q_OVERFLOW != 0 {
+		dir.log.Warn().Msg("inotify queue overflowed, rescanning")
+		err := w.addRecursive(dir, dir.monitorFolder())
+		if err != nil {
+			dir.log.Error().Err(err).Msg("failed to rewatch folder")
+		}
+		_ = dir.Rescan()
+		return
+	}
+	if mask&unix.IN_IGNORED != 0 {
+		delete(w.watches, wd)
+		return
+	}
+
+	parent, ok := w.watches[wd]
+	if !ok {
+		return
+	}
+	path := filepath.Join(parent, name)
+
+	if mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+		dir.forgetEmpty(parent)
+	}
+	if mask&unix.IN_ISDIR != 0 {
+		switch {
+		case mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0:
+			err := w.addRecursive(dir, path)
+			if err != nil {
+				dir.log.Error().Err(err).Str("dir", path).Msg("failed to watch new directory")
+			}
+			// treated as root so a directory that was just created is not removed before it is written to
+			_ = dir.readDir(path, true)
+		case mask&unix.IN_MOVED_FROM != 0:
+			w.removeRecursive(path)
+		}
+		return
+	}
+
+	switch {
+	case mask&(unix.IN_CLOSE_WRITE|unix.IN_MOVED_TO) != 0:
+		dir.Schedule(parent, name)
+	case mask&(unix.IN_DELETE|unix.IN_MOVED_FROM) != 0:
+		dir.removeIfEmpty(parent)
+	}
+}
+
+func (w *watcherNotify) addRecursive(dir *Dir, root string) error {
+	return filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			return nil // removed while walking
+		}
+		if !entry.IsDir() {
+			return nil
+		} else if dir.isInternalFolder(path) {
+			return fs.SkipDir
+		}
+
+		wd, err := unix.InotifyAddWatch(w.fd, path, notifyMask)
+		if err != nil {
+			return fmt.Errorf("failed to watch %s: %w", path, err)
+		}
+		w.watches[wd] = path
+		return nil
+	})
+}
+
+// Removes the watches for a directory that was moved out of the monitor folder
+func (w *watcherNotify) removeRecursive(root string) {
+	for wd, path := range w.watches {
+		if path == root || strings.HasPrefix(path, root+string(filepath.Separator)) {
+			_, _ = unix.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.watches, wd)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+//go:build linux
+
+package fileMonitor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/treavorj/zerolog"
+	"github.com/treavorj/zerolog/log"
+	"github.com/treavorj/zerolog/pkgerrors"
+)
+
+func TestWatcherNotify(t *testing.T) {
+	t.Parallel()
+
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+	logger := log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339Nano}).With().Caller().Logger()
+
+	monitorFolder := t.TempDir()
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(configFile, []byte("{}"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	fileMonitor, err := NewFileMonitor(context.Background(), logger, configFile)
+	if err != nil {
+		t.Fatalf("failed to create fileMonitor: %v", err)
+	} else if fileMonitor == nil {
+		t.Fatalf("fileMonitor is nil")
+	}
+
+	destination := t.TempDir()
+	dir := &Dir{
+		Name:             t.Name(),
+		MonitorFolder:    monitorFolder,
+		MonitorFrequency: time.Hour, // only notifications can pick up the files in time
+		WatcherType:      WatcherTypeNotify,
+		Copiers:          []Copier{&CopierLocal{Destination: destination}},
+	}
+	err = fileMonitor.AddDir(dir)
+	if err != nil {
+		t.Fatalf("failed to add dir: %v", err)
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	// file in the root and in a directory created after the watch started
+	err = os.WriteFile(filepath.Join(monitorFolder, "root.csv"), []byte("root"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	err = os.Mkdir(filepath.Join(monitorFolder, "sub"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to create sub folder: %v", err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	err = os.WriteFile(filepath.Join(monitorFolder, "sub", "nested.csv"), []byte("nested"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	time.Sleep(time.Millisecond * 600)
+	for _, name := range []string{"root.csv", filepath.Join("sub", "nested.csv")} {
+		_, err = os.Stat(filepath.Join(monitorFolder, name))
+		if !os.IsNotExist(err) {
+			t.Errorf("%s should not exist but does", name)
+		}
+		_, err = os.Stat(filepath.Join(destination, name))
+		if err != nil {
+			t.Errorf("%s should have been copied: %v", name, err)
+		}
+	}
+}
+
+func TestRemoveEmptyDirs(t *testing.T) {
+	t.Parallel()
+
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+	logger := log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339Nano}).With().Caller().Logger()
+
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(configFile, []byte("{}"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	fileMonitor, err := NewFileMonitor(context.Background(), logger, configFile)
+	if err != nil {
+		t.Fatalf("failed to create fileMonitor: %v", err)
+	} else if fileMonitor == nil {
+		t.Fatalf("fileMonitor is nil")
+	}
+
+	tests := []struct {
+		name            string
+		watcherType     WatcherType
+		removeEmptyDirs bool
+		keepEmptyDirs   bool
+	}{
+		{"pollDefault", WatcherTypePoll, false, false},
+		{"pollRemove", WatcherTypePoll, true, false},
+		{"pollKeep", WatcherTypePoll, true, true},
+		{"notifyDefault", WatcherTypeNotify, false, false},
+		{"notifyRemove", WatcherTypeNotify, true, false},
+		{"notifyKeep", WatcherTypeNotify, true, true},
+	}
+	monitorFolders := make(map[string]string)
+	for _, test := range tests {
+		monitorFolder := t.TempDir()
+		monitorFolders[test.name] = monitorFolder
+		err = os.Mkdir(filepath.Join(monitorFolder, "empty"), os.ModePerm)
+		if err != nil {
+			t.Fatalf("failed to create empty folder: %v", err)
+		}
+
+		err = fileMonitor.AddDir(&Dir{
+			Name:             test.name,
+			MonitorFolder:    monitorFolder,
+			MonitorFrequency: time.Millisecond * 50,
+			WatcherType:      test.watcherType,
+			RemoveEmptyDirs:  test.removeEmptyDirs,
+			KeepEmptyDirs:    test.keepEmptyDirs,
+			Copiers:          []Copier{&CopierLocal{Destination: t.TempDir()}},
+		})
+		if err != nil {
+			t.Fatalf("%s: failed to add dir: %v", test.name, err)
+		}
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	// moved in with the file so the poll watcher cannot remove the folder before the file is written
+	for _, test := range tests {
+		sub := filepath.Join(t.TempDir(), "sub")
+		err = os.Mkdir(sub, os.ModePerm)
+		if err != nil {
+			t.Fatalf("failed to create sub folder: %v", err)
+		}
+		err = os.WriteFile(filepath.Join(sub, "nested.csv"), []byte("nested"), os.ModePerm)
+		if err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		err = os.Rename(sub, filepath.Join(monitorFolders[test.name], "sub"))
+		if err != nil {
+			t.Fatalf("failed to move sub folder: %v", err)
+		}
+	}
+
+	time.Sleep(time.Millisecond * 600)
+	for _, test := range tests {
+		_, err = os.Stat(filepath.Join(monitorFolders[test.name], "sub", "nested.csv"))
+		if !os.IsNotExist(err) {
+			t.Errorf("%s: file should have been processed: %v", test.name, err)
+		}
+		for _, folder := range []string{"empty", "sub"} {
+			_, err = os.Stat(filepath.Join(monitorFolders[test.name], folder))
+			removed := test.removeEmptyDirs && !test.keepEmptyDirs
+			if !removed && err != nil {
+				t.Errorf("%s: empty folder %s should have been kept: %v", test.name, folder, err)
+			} else if removed && !os.IsNotExist(err) {
+				t.Errorf("%s: empty folder %s should have been removed: %v", test.name, folder, err)
+			}
+		}
+	}
+
+	// folders are only removed once empty for a full MonitorFrequency so writers can create the folder first
+	monitorFolder := t.TempDir()
+	err = fileMonitor.AddDir(&Dir{
+		Name:             "late",
+		MonitorFolder:    monitorFolder,
+		MonitorFrequency: time.Millisecond * 500,
+		RemoveEmptyDirs:  true,
+		Copiers:          []Copier{&CopierLocal{Destination: t.TempDir()}},
+	})
+	if err != nil {
+		t.Fatalf("failed to add dir: %v", err)
+	}
+	for n := range 3 {
+		late := filepath.Join(monitorFolder, fmt.Sprintf("late%d", n))
+		err = os.Mkdir(late, os.ModePerm)
+		if err != nil {
+			t.Fatalf("failed to create folder: %v", err)
+		}
+		time.Sleep(time.Millisecond * 200)
+		err = os.WriteFile(filepath.Join(late, "late.csv"), []byte("late"), os.ModePerm)
+		if err != nil {
+			t.Errorf("folder should not have been removed before the file was written: %v", err)
+		}
+	}
+}
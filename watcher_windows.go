@@ -0,0 +1,9 @@
+//go:build windows
+
+package fileMonitor
+
+import "fmt"
+
+func newWatcherNotify() (Watcher, error) {
+	return nil, fmt.Errorf("notify watcher is not supported on windows")
+}
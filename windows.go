@@ -5,6 +5,7 @@ package fileMonitor
 import (
 	"bytes"
 	"fmt"
+	"os"
 	"os/exec"
 	"syscall"
 	"time"
@@ -39,6 +40,45 @@ func getCreationTime(filePath string) (time.Time, error) {
 	return time.Unix(0, cTime.Nanoseconds()), nil
 }
 
+// Owners are part of the security descriptor and are not copied, neither are alternate data streams
+func copyFileMetadata(inFile, outFile *os.File, inStats os.FileInfo) error {
+	return nil
+}
+
+func getFileId(filePath string) (fileId, error) {
+	h, err := windows.CreateFile(windows.StringToUTF16Ptr(filePath), 0, windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE, nil, windows.OPEN_EXISTING, windows.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return fileId{}, err
+	}
+	defer windows.CloseHandle(h)
+
+	var info windows.ByHandleFileInformation
+	if err := windows.GetFileInformationByHandle(h, &info); err != nil {
+		return fileId{}, err
+	}
+
+	return fileId{device: uint64(info.VolumeSerialNumber), inode: uint64(info.FileIndexHigh)<<32 | uint64(info.FileIndexLow)}, nil
+}
+
+// Checks if the file can be opened without sharing, failing if another process has it open
+func probeExclusive(filePath string) error {
+	h, err := windows.CreateFile(windows.StringToUTF16Ptr(filePath), windows.GENERIC_READ, 0, nil, windows.OPEN_EXISTING, windows.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		return err
+	}
+	return windows.CloseHandle(h)
+}
+
+// Checks files can be created in the folder by opening it with the access needed to add a file
+func checkWritable(folder string) error {
+	h, err := windows.CreateFile(windows.StringToUTF16Ptr(folder), windows.FILE_WRITE_DATA, windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE, nil, windows.OPEN_EXISTING, windows.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return err
+	}
+	return windows.CloseHandle(h)
+}
+
+// Deprecated: requires root and stores the credentials on the host. Use CopierSmb or an smb:// MonitorFolder instead
 func SmbMount(username, password, server, shareName string) error {
 	if shareName == "" {
 		return fmt.Errorf("shareName cannot be blank")
@@ -70,6 +110,7 @@ func SmbMount(username, password, server, shareName string) error {
 	return nil
 }
 
+// Deprecated: only needed for shares mounted with SmbMount
 func SmbRemove(server, shareName string) error {
 	if shareName == "" {
 		return fmt.Errorf("shareName cannot be blank")